@@ -8,9 +8,12 @@ import (
 	"path"
 	"path/filepath"
 
+	"github.com/docker/docker/client"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
 	"github.com/harness/drone-ci-docker-extension/pkg/handler"
 	"github.com/harness/drone-ci-docker-extension/pkg/monitor"
 	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/harness/drone-ci-docker-extension/pkg/watcher"
 	echo "github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 )
@@ -19,10 +22,13 @@ func main() {
 	var log *logrus.Logger
 	var err error
 	var socketPath, v, dbFile string
+	var uiRefreshContainer, watchPipelines bool
 
 	flag.StringVar(&socketPath, "socket", "/run/guest/volumes-service.sock", "Unix domain socket to listen on")
 	flag.StringVar(&dbFile, "dbPath", utils.LookupEnvOrString("DB_FILE", "/data/db"), "File to store the Drone Pipeline Info")
 	flag.StringVar(&v, "level", utils.LookupEnvOrString("LOG_LEVEL", logrus.WarnLevel.String()), "The log level to use. Allowed values trace,debug,info,warn,fatal,panic.")
+	flag.BoolVar(&uiRefreshContainer, "ui-refresh-container", utils.LookupEnvOrBool("UI_REFRESH_CONTAINER", false), "Notify the extension UI of status changes by starting a labelled container, in addition to the events endpoint")
+	flag.BoolVar(&watchPipelines, "watch", utils.LookupEnvOrBool("WATCH_PIPELINES", true), "Keep the imported pipelines in sync with the changes of their pipeline files")
 	flag.Parse()
 
 	os.RemoveAll(socketPath)
@@ -44,7 +50,24 @@ func main() {
 	//Init DB
 	ctx := context.Background()
 
-	h := handler.NewHandler(ctx, dbFile, log)
+	dockerCli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var eventOpts []events.Option
+	if uiRefreshContainer {
+		eventOpts = append(eventOpts, events.WithFallback(func(ctx context.Context) error {
+			return utils.TriggerUIRefresh(ctx, dockerCli, log)
+		}))
+	}
+	broker := events.NewBroker(ctx, log, eventOpts...)
+
+	logsPath := path.Join(filepath.Dir(dbFile), "logs")
+	h := handler.NewHandler(ctx, dbFile, log,
+		handler.WithLogsPath(logsPath),
+		handler.WithEvents(broker),
+		handler.WithDockerClient(dockerCli))
 
 	//Routes
 	router.GET("/stages", h.GetStages)
@@ -56,28 +79,59 @@ func main() {
 	router.DELETE("/stages", h.DeleteAllStages)
 	router.DELETE("/stages/:id", h.DeleteStage)
 	router.DELETE("/pipeline/:pipelineFile", h.DeletePipeline)
-	//TODO stream
 	router.GET("/stage/:id/logs", h.StageLogs)
+	router.GET("/stage/:id/step/:stepId/logs", h.StepLogs)
+	router.GET("/stage/:id/runs", h.GetStageRuns)
+	router.GET("/run/:id", h.GetRun)
+	router.POST("/stage/:id/run", h.RunStage)
+	router.POST("/stage/:id/step/:stepId/run", h.RunStep)
+	router.POST("/stage/:id/cancel", h.CancelStage)
+	router.POST("/run/:id/cancel", h.CancelRun)
+	router.POST("/lint", h.Lint)
+	router.GET("/events", h.StreamEvents)
 
 	//Start the monitor to monitor pipeline
 	//Save logs and update statuses
-	logsPath := path.Join(filepath.Dir(dbFile), "logs")
 	log.Infof("Saving pipeline logs in %s\n", logsPath)
 	cfg, err := monitor.New(h.DatabaseConfig.Ctx,
 		h.DatabaseConfig.DB,
-		h.DatabaseConfig.Log, monitor.WithLogsPath(logsPath))
+		h.DatabaseConfig.Log,
+		monitor.WithLogsPath(logsPath),
+		monitor.WithEvents(broker))
 
 	if err != nil {
 		log.Fatal(err)
 	} else {
+		//Drain the monitor errors so that the monitor is never blocked on reporting them
+		go func() {
+			for err := range cfg.MonitorErrors {
+				log.Error(err.Error())
+			}
+		}()
 		go cfg.MonitorAndLog()
+		//The runs executed by the backend are reported straight to the database,
+		//the monitor is the fallback for the runs executed elsewhere
+		h.Monitor = cfg
 	}
 
-	log.Fatal(router.Start(startURL))
-	for {
-		errCh := <-cfg.MonitorErrors
-		log.Error(errCh.Error())
+	//Watch the pipeline files to update the stages as the files are edited
+	if watchPipelines {
+		w, err := watcher.New(h.DatabaseConfig.Ctx,
+			h.DatabaseConfig.DB,
+			h.DatabaseConfig.Log,
+			watcher.WithEvents(broker))
+		if err != nil {
+			log.Errorf("Unable to watch the pipeline files, %v", err)
+		} else {
+			if err := w.Sync(); err != nil {
+				log.Errorf("Error watching the pipeline files, %v", err)
+			}
+			go w.Watch()
+			h.Watcher = w
+		}
 	}
+
+	log.Fatal(router.Start(startURL))
 }
 
 func listen(path string) (net.Listener, error) {
@@ -20,6 +20,8 @@ func main() {
 
 	app.Commands = []*cli.Command{
 		drone.Command,
+		drone.CompileCommand,
+		drone.LintCommand,
 	}
 
 	if err := app.Run(os.Args); err != nil {
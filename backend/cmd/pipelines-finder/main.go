@@ -2,126 +2,92 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
 	"strings"
 
-	"github.com/harness/drone-ci-docker-extension/pkg/ignore"
+	"github.com/harness/drone-ci-docker-extension/pkg/finder"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
 )
 
-type Stage struct {
-	PipelineFile string    `json:"pipelineFile"`
-	PipelinePath string    `json:"pipelinePath"`
-	Name         string    `json:"name"`
-	Steps        []Step    `json:"steps"`
-	Services     []Service `json:"-"`
+// Output is the discovered stages along with the problems of the pipeline files
+type Output struct {
+	Stages finder.Stages      `json:"stages"`
+	Errors []finder.FileError `json:"errors"`
 }
 
-type Step struct {
-	Name    string `json:"name"`
-	Image   string `json:"image"`
-	Service int    `json:"isService"`
-}
+// patterns are the values of the repeated -pattern flag
+type patterns []string
 
-type Service struct {
-	Name  string `json:"name"`
-	Image string `json:"image"`
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
 }
 
-type Stages []*Stage
+func (p *patterns) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
 
 func main() {
-	var directory string
-	var stages Stages
+	var directory, configFile string
+	var filePatterns patterns
+	var maxDepth, workers int
+	var followSymlinks bool
 
 	flag.StringVar(&directory, "path", "", "Root Path to discover drone pipelines")
+	flag.StringVar(&configFile, "config", "", fmt.Sprintf("Config file of the discovery, defaults to the %s of the root path", finder.ConfigFile))
+	flag.Var(&filePatterns, "pattern", fmt.Sprintf("Glob pattern of the pipeline files e.g. ci/*.yml, could be repeated (default %s)", strings.Join(finder.DefaultPatterns, ",")))
+	flag.IntVar(&maxDepth, "max-depth", -1, "Max depth of the directories to look in, 0 for the root path only and -1 for unlimited")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "Follow the symbolic links to the directories outside the root path")
+	flag.IntVar(&workers, "workers", 0, "Number of directories read concurrently (default 4 per CPU)")
 	flag.Parse()
 
 	if directory == "" {
 		log.Fatal("Require base directory to discover pipelines. Run the command with e.g. pipelines-finder -path <base dir path>")
 	}
 
-	ignorer, err := ignore.NewOrDefault(directory)
-
+	config, err := finder.ReadConfig(directory, configFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	//TODO use WalkDir
-	err = filepath.Walk(directory, func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			fmt.Println(err)
-			return nil
-		}
-		var ignorable ignore.Ignorable
-		if ignorable, err = ignorer.CanIgnore(path, fi); err != nil {
-			return err
+	// the flags take precedence over the config file
+	opts := config.Options()
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "pattern":
+			opts = append(opts, finder.WithPatterns(filePatterns...))
+		case "max-depth":
+			opts = append(opts, finder.WithMaxDepth(maxDepth))
+		case "follow-symlinks":
+			opts = append(opts, finder.WithFollowSymlinks(followSymlinks))
+		case "workers":
+			opts = append(opts, finder.WithWorkers(workers))
 		}
-
-		switch ignorable {
-		case ignore.Transitive:
-			return filepath.SkipDir
-		}
-
-		// Chase symlinks.
-		info, err := os.Stat(path)
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() && strings.HasSuffix(path, ".drone.yml") {
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			file, err := os.Open(path)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			decoder := yaml.NewDecoder(file)
-			for {
-				stage := new(Stage)
-				stage.PipelineFile = path
-				stage.PipelinePath = filepath.Dir(path)
-				err := decoder.Decode(stage)
-				if stage == nil {
-					continue
-				}
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				if err != nil {
-					log.Fatal(err)
-				}
-				for _, svc := range stage.Services {
-					stage.Steps = append(stage.Steps, Step{
-						Name:    svc.Name,
-						Image:   svc.Image,
-						Service: 1,
-					})
-				}
-				stages = append(stages, stage)
-			}
-		}
-
-		return nil
 	})
 
+	f, err := finder.New(directory, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files, err := f.Find()
 	if err != nil {
 		log.Fatal(err)
 	}
-	//sort.Sort(stages)
-	b, err := json.Marshal(stages)
+
+	out := Output{
+		Stages: finder.Stages{},
+		Errors: []finder.FileError{},
+	}
+	for _, file := range files {
+		stages, errs := finder.ReadStages(file)
+		out.Stages = append(out.Stages, stages...)
+		out.Errors = append(out.Errors, errs...)
+	}
+
+	b, err := json.Marshal(out)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Printf(string(b))
+	fmt.Print(string(b))
 }
@@ -10,13 +10,17 @@ require (
 )
 
 require (
+	filippo.io/age v1.0.0
+	github.com/buildkite/yaml v2.1.0+incompatible
 	github.com/docker/docker v0.0.0-00010101000000-000000000000
 	github.com/drone-runners/drone-runner-docker v1.8.2
 	github.com/drone/drone-go v1.7.1
 	github.com/drone/envsubst v1.0.3
 	github.com/drone/runner-go v1.12.0
 	github.com/drone/signal v1.0.0
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/google/go-cmp v0.5.8
+	github.com/google/go-jsonnet v0.19.1
 	github.com/joho/godotenv v1.3.0
 	github.com/stretchr/testify v1.8.0
 	github.com/uptrace/bun v1.1.8
@@ -25,6 +29,7 @@ require (
 	github.com/uptrace/bun/driver/sqliteshim v1.1.8
 	github.com/uptrace/bun/extra/bundebug v1.1.8
 	github.com/urfave/cli/v2 v2.20.3
+	go.starlark.net v0.0.0-20220926145019-14b050677505
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -32,7 +37,6 @@ require (
 	github.com/99designs/httpsignatures-go v0.0.0-20170731043157-88528bf4ca7e // indirect
 	github.com/Microsoft/go-winio v0.5.2 // indirect
 	github.com/bmatcuk/doublestar v1.1.1 // indirect
-	github.com/buildkite/yaml v2.1.0+incompatible // indirect
 	github.com/containerd/containerd v1.3.4 // indirect
 	github.com/coreos/go-semver v0.3.0 // indirect
 	github.com/cpuguy83/go-md2man/v2 v2.0.2 // indirect
@@ -77,6 +81,7 @@ require (
 	modernc.org/sqlite v1.18.1 // indirect
 	modernc.org/strutil v1.1.3 // indirect
 	modernc.org/token v1.0.1 // indirect
+	sigs.k8s.io/yaml v1.2.0 // indirect
 )
 
 require (
@@ -89,7 +94,7 @@ require (
 	github.com/valyala/fasttemplate v1.2.1 // indirect
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/net v0.0.0-20220722155237-a158d28d115b // indirect
-	golang.org/x/sys v0.0.0-20220825204002-c680a09ffe64 // indirect
+	golang.org/x/sys v0.1.0 // indirect
 	golang.org/x/text v0.3.7 // indirect
 	k8s.io/apimachinery v0.24.2
 )
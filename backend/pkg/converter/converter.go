@@ -0,0 +1,141 @@
+package converter
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/drone/drone-go/drone"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	//ExtYAML is the extension of the YAML pipeline files
+	ExtYAML = ".yml"
+	//ExtJsonnet is the extension of the Jsonnet pipeline files
+	ExtJsonnet = ".jsonnet"
+	//ExtStarlark is the extension of the Starlark pipeline files
+	ExtStarlark = ".star"
+)
+
+// pipelineFileSuffixes are the suffixes of the names of the pipeline files of the known formats
+var pipelineFileSuffixes = []string{
+	".drone" + ExtYAML,
+	".drone" + ExtJsonnet,
+	".drone" + ExtStarlark,
+}
+
+// Context is the stub of the drone built-in context that the configs are rendered with
+type Context struct {
+	Build *drone.Build
+	Repo  *drone.Repo
+}
+
+// Stub is the context to render the configs when the build is not known e.g. to discover the
+// pipelines, it is a push to the main branch
+func Stub() *Context {
+	return &Context{
+		Build: &drone.Build{
+			Event:  drone.EventPush,
+			Source: "main",
+			Target: "main",
+			Ref:    "refs/heads/main",
+		},
+		Repo: &drone.Repo{
+			Branch: "main",
+		},
+	}
+}
+
+// IsPipelineFile reports whether the file is a pipeline file of a known format e.g. .drone.yml or .drone.jsonnet
+func IsPipelineFile(file string) bool {
+	for _, suffix := range pipelineFileSuffixes {
+		if strings.HasSuffix(file, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert renders the data of the pipeline file to YAML by the format of the file extension,
+// the YAML files are returned as is. The stub context is used when ctx is nil.
+func Convert(file string, data []byte, ctx *Context) ([]byte, error) {
+	out, _, err := ConvertWithImports(file, data, ctx)
+	return out, err
+}
+
+// ConvertWithImports is Convert that also returns the files imported by the Jsonnet config or
+// loaded by the Starlark config, the imports are returned even when the config could not be
+// rendered e.g. to watch an import that has to be fixed
+func ConvertWithImports(file string, data []byte, ctx *Context) ([]byte, []string, error) {
+	stub := Stub()
+	if ctx == nil {
+		ctx = stub
+	}
+	if ctx.Build == nil || ctx.Repo == nil {
+		c := *ctx
+		if c.Build == nil {
+			c.Build = stub.Build
+		}
+		if c.Repo == nil {
+			c.Repo = stub.Repo
+		}
+		ctx = &c
+	}
+
+	var docs [][]byte
+	var imports []string
+	seen := make(map[string]bool)
+	imported := func(path string) {
+		path = filepath.Clean(path)
+		if !seen[path] {
+			seen[path] = true
+			imports = append(imports, path)
+		}
+	}
+	var err error
+	switch filepath.Ext(file) {
+	case ExtJsonnet:
+		docs, err = convertJsonnet(file, data, ctx, imported)
+	case ExtStarlark:
+		docs, err = convertStarlark(file, data, ctx, imported)
+	default:
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, imports, err
+	}
+	out, err := toYAML(docs)
+	return out, imports, err
+}
+
+// toYAML joins the JSON documents as the YAML documents, the keys are kept in the order of the JSON
+func toYAML(docs [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for i, doc := range docs {
+		node := new(yaml.Node)
+		if err := yaml.Unmarshal(doc, node); err != nil {
+			return nil, fmt.Errorf("unable to convert document %d to YAML, %w", i+1, err)
+		}
+		blockStyle(node)
+		if err := enc.Encode(node); err != nil {
+			return nil, fmt.Errorf("unable to convert document %d to YAML, %w", i+1, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blockStyle drops the flow and the quoting styles of JSON, the strings that would not be
+// strings when plain are still quoted by the encoder
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		blockStyle(n)
+	}
+}
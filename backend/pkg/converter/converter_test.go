@@ -0,0 +1,161 @@
+package converter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonnetPipeline = `
+local pipeline(name, image) = {
+  kind: 'pipeline',
+  type: 'docker',
+  name: name,
+  steps: [
+    {
+      name: 'build',
+      image: image,
+      commands: ['echo ' + std.extVar('build.branch')],
+    },
+  ],
+};
+
+[
+  pipeline('go', 'golang:1.19'),
+  pipeline('node', 'node:18'),
+]
+`
+
+// the keys are sorted by the evaluators, the same as the drone server renders them
+const wantJsonnet = `kind: pipeline
+name: go
+steps:
+  - commands:
+      - echo main
+    image: golang:1.19
+    name: build
+type: docker
+---
+kind: pipeline
+name: node
+steps:
+  - commands:
+      - echo main
+    image: node:18
+    name: build
+type: docker
+`
+
+const starlarkPipeline = `
+load("steps.star", "step")
+
+def main(ctx):
+    return {
+        "kind": "pipeline",
+        "type": "docker",
+        "name": "default",
+        "steps": [
+            step("build", "echo " + ctx.build.branch),
+        ],
+        "trigger": {"event": [ctx.build.event]},
+    }
+`
+
+const starlarkSteps = `
+def step(name, command):
+    return {
+        "name": name,
+        "image": "alpine",
+        "commands": [command],
+    }
+`
+
+const wantStarlark = `kind: pipeline
+name: default
+steps:
+  - commands:
+      - echo release
+    image: alpine
+    name: build
+trigger:
+  event:
+    - tag
+type: docker
+`
+
+func TestConvert(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "steps.star"), []byte(starlarkSteps), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Convert(filepath.Join(dir, ".drone.jsonnet"), []byte(jsonnetPipeline), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, wantJsonnet, string(got))
+	}
+
+	got, err = Convert(filepath.Join(dir, ".drone.star"), []byte(starlarkPipeline), &Context{
+		Build: &drone.Build{Event: drone.EventTag, Target: "release"},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, wantStarlark, string(got))
+	}
+
+	yml := []byte("kind: pipeline\nname: default\n")
+	got, err = Convert(filepath.Join(dir, ".drone.yml"), yml, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, yml, got)
+
+	_, err = Convert(filepath.Join(dir, ".drone.star"), []byte("def main(ctx):\n    return 42\n"), nil)
+	assert.ErrorContains(t, err, "main returned a int, want a dict or a list of dicts")
+
+	_, err = Convert(filepath.Join(dir, ".drone.star"), []byte("x = 1\n"), nil)
+	assert.ErrorContains(t, err, "no main function")
+}
+
+func TestConvertWithImports(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "steps.star"), []byte(starlarkSteps), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "steps.libsonnet"), []byte("{ image: 'alpine' }\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, imports, err := ConvertWithImports(filepath.Join(dir, ".drone.star"), []byte(starlarkPipeline), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{filepath.Join(dir, "steps.star")}, imports)
+	}
+
+	jsonnetImports := `local steps = import 'steps.libsonnet';
+{ kind: 'pipeline', name: 'default', image: steps.image }
+`
+	_, imports, err = ConvertWithImports(filepath.Join(dir, ".drone.jsonnet"), []byte(jsonnetImports), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{filepath.Join(dir, "steps.libsonnet")}, imports)
+	}
+
+	// the missing imports are still returned for them to be watched
+	_, imports, err = ConvertWithImports(filepath.Join(dir, ".drone.jsonnet"), []byte("import 'missing.libsonnet'\n"), nil)
+	assert.Error(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "missing.libsonnet")}, imports)
+
+	_, imports, err = ConvertWithImports(filepath.Join(dir, ".drone.star"), []byte("load(\"missing.star\", \"step\")\n"), nil)
+	assert.Error(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "missing.star")}, imports)
+
+	_, imports, err = ConvertWithImports(filepath.Join(dir, ".drone.yml"), []byte("kind: pipeline\n"), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, imports)
+}
+
+func TestIsPipelineFile(t *testing.T) {
+	assert.True(t, IsPipelineFile("/tmp/examples/.drone.yml"))
+	assert.True(t, IsPipelineFile("/tmp/examples/.drone.jsonnet"))
+	assert.True(t, IsPipelineFile("/tmp/examples/.drone.star"))
+	assert.False(t, IsPipelineFile("/tmp/examples/steps.star"))
+	assert.False(t, IsPipelineFile("/tmp/examples/docker-compose.yml"))
+}
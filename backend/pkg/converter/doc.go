@@ -0,0 +1,21 @@
+/*
+Copyright 2022 Kamesh Sampath<kamesh.sampath@hotmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package converter renders the pipeline files written in Jsonnet (.drone.jsonnet) or Starlark (.drone.star)
+// to the YAML documents of the stages, the same way the drone server converts them. The configs are rendered
+// with a stub of the drone built-in context e.g. ctx.build.branch of Starlark or std.extVar("build.branch")
+// of Jsonnet, that is filled with the build details known locally.
+package converter
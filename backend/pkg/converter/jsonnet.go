@@ -0,0 +1,111 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet"
+)
+
+// convertJsonnet evaluates the Jsonnet config, a top level array is a document per item.
+// The files imported by the config are resolved relative to it and passed to imported.
+func convertJsonnet(file string, data []byte, ctx *Context, imported func(string)) ([][]byte, error) {
+	vm := jsonnet.MakeVM()
+	vm.MaxStack = 500
+	vm.ErrorFormatter.SetMaxStackTraceSize(20)
+	vm.Importer(&recordingImporter{imported: imported})
+	for k, v := range extVars(ctx) {
+		vm.ExtVar(k, v)
+	}
+
+	out, err := vm.EvaluateSnippet(file, string(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to evaluate %s, %w", file, err)
+	}
+
+	trimmed := bytes.TrimSpace([]byte(out))
+	if !bytes.HasPrefix(trimmed, []byte("[")) {
+		return [][]byte{trimmed}, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, fmt.Errorf("unable to evaluate %s, %w", file, err)
+	}
+	docs := make([][]byte, len(items))
+	for i, item := range items {
+		docs[i] = item
+	}
+	return docs, nil
+}
+
+// recordingImporter is the file importer that passes the path of each imported file to imported,
+// the path the file would be at when it could not be found
+type recordingImporter struct {
+	jsonnet.FileImporter
+	imported func(string)
+}
+
+func (i *recordingImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	contents, foundAt, err := i.FileImporter.Import(importedFrom, importedPath)
+	switch {
+	case err == nil:
+		i.imported(foundAt)
+	case !filepath.IsAbs(importedPath):
+		i.imported(filepath.Join(filepath.Dir(importedFrom), importedPath))
+	}
+	return contents, foundAt, err
+}
+
+// extVars are the external variables of the context, the same as the ones of the drone server
+// e.g. std.extVar("build.branch")
+func extVars(ctx *Context) map[string]string {
+	build, repo := ctx.Build, ctx.Repo
+	vars := map[string]string{
+		"build.event":         build.Event,
+		"build.action":        build.Action,
+		"build.environment":   build.Deploy,
+		"build.link":          build.Link,
+		"build.branch":        build.Target,
+		"build.source":        build.Source,
+		"build.before":        build.Before,
+		"build.after":         build.After,
+		"build.target":        build.Target,
+		"build.ref":           build.Ref,
+		"build.commit":        build.After,
+		"build.title":         build.Title,
+		"build.message":       build.Message,
+		"build.source_repo":   build.Fork,
+		"build.author_login":  build.Author,
+		"build.author_name":   build.AuthorName,
+		"build.author_email":  build.AuthorEmail,
+		"build.author_avatar": build.AuthorAvatar,
+		"build.sender":        build.Sender,
+		"build.debug":         strconv.FormatBool(build.Debug),
+		"build.cron":          build.Cron,
+
+		"repo.uid":                  repo.UID,
+		"repo.name":                 repo.Name,
+		"repo.namespace":            repo.Namespace,
+		"repo.slug":                 repo.Slug,
+		"repo.git_http_url":         repo.HTTPURL,
+		"repo.git_ssh_url":          repo.SSHURL,
+		"repo.link":                 repo.Link,
+		"repo.branch":               repo.Branch,
+		"repo.config":               repo.Config,
+		"repo.private":              strconv.FormatBool(repo.Private),
+		"repo.visibility":           repo.Visibility,
+		"repo.active":               strconv.FormatBool(repo.Active),
+		"repo.trusted":              strconv.FormatBool(repo.Trusted),
+		"repo.protected":            strconv.FormatBool(repo.Protected),
+		"repo.ignore_forks":         strconv.FormatBool(repo.IgnoreForks),
+		"repo.ignore_pull_requests": strconv.FormatBool(repo.IgnorePulls),
+	}
+	for k, v := range build.Params {
+		vars["build.params."+strings.ToLower(k)] = v
+	}
+	return vars
+}
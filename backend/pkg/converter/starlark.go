@@ -0,0 +1,158 @@
+package converter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// maxExecutionSteps limits the steps of the Starlark configs, the same as the drone server
+const maxExecutionSteps = 50000
+
+// convertStarlark calls the main function of the Starlark config with the context, main returns
+// either a dict or a list of dicts that are a document each. The modules loaded by the config
+// are resolved relative to it and passed to imported.
+func convertStarlark(file string, data []byte, ctx *Context, imported func(string)) ([][]byte, error) {
+	thread := &starlark.Thread{
+		Name: "drone",
+		Load: loader(filepath.Dir(file), imported),
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Debugf("%s: %s", file, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxExecutionSteps)
+
+	globals, err := starlark.ExecFile(thread, file, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to evaluate %s, %w", file, err)
+	}
+	mainFn, ok := globals["main"]
+	if !ok {
+		return nil, fmt.Errorf("unable to evaluate %s, no main function", file)
+	}
+	v, err := starlark.Call(thread, mainFn, starlark.Tuple{starlarkContext(ctx)}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to evaluate %s, %w", file, err)
+	}
+
+	var values []starlark.Value
+	switch v := v.(type) {
+	case *starlark.Dict:
+		values = append(values, v)
+	case *starlark.List:
+		for i := 0; i < v.Len(); i++ {
+			item, ok := v.Index(i).(*starlark.Dict)
+			if !ok {
+				return nil, fmt.Errorf("unable to evaluate %s, item %d returned by main is a %s, want a dict", file, i+1, v.Index(i).Type())
+			}
+			values = append(values, item)
+		}
+	default:
+		return nil, fmt.Errorf("unable to evaluate %s, main returned a %s, want a dict or a list of dicts", file, v.Type())
+	}
+
+	encode := starlarkjson.Module.Members["encode"]
+	docs := make([][]byte, len(values))
+	for i, value := range values {
+		out, err := starlark.Call(thread, encode, starlark.Tuple{value}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to evaluate %s, %w", file, err)
+		}
+		docs[i] = []byte(out.(starlark.String).GoString())
+	}
+	return docs, nil
+}
+
+// loader loads the modules of the load statements from the files relative to the dir, the path of
+// each module is passed to imported
+func loader(dir string, imported func(string)) func(*starlark.Thread, string) (starlark.StringDict, error) {
+	type entry struct {
+		globals starlark.StringDict
+		err     error
+	}
+	cache := make(map[string]*entry)
+	var load func(*starlark.Thread, string) (starlark.StringDict, error)
+	load = func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		path := filepath.Join(dir, module)
+		e, ok := cache[path]
+		if ok {
+			if e == nil {
+				return nil, fmt.Errorf("cycle in the load graph of %s", module)
+			}
+			return e.globals, e.err
+		}
+		// a nil entry marks the module as being loaded
+		cache[path] = nil
+		imported(path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			cache[path] = &entry{err: err}
+			return nil, err
+		}
+		t := &starlark.Thread{Name: "load " + module, Load: load, Print: thread.Print}
+		t.SetMaxExecutionSteps(maxExecutionSteps)
+		globals, err := starlark.ExecFile(t, path, data, nil)
+		cache[path] = &entry{globals, err}
+		return globals, err
+	}
+	return load
+}
+
+// starlarkContext is the ctx argument of the main function e.g. ctx.build.branch
+func starlarkContext(ctx *Context) starlark.Value {
+	build, repo := ctx.Build, ctx.Repo
+	params := new(starlark.Dict)
+	for k, v := range build.Params {
+		params.SetKey(starlark.String(k), starlark.String(v))
+	}
+	return starlarkstruct.FromStringDict(starlark.String("context"), starlark.StringDict{
+		"build": starlarkstruct.FromStringDict(starlark.String("build"), starlark.StringDict{
+			"event":         starlark.String(build.Event),
+			"action":        starlark.String(build.Action),
+			"environment":   starlark.String(build.Deploy),
+			"link":          starlark.String(build.Link),
+			"branch":        starlark.String(build.Target),
+			"source":        starlark.String(build.Source),
+			"before":        starlark.String(build.Before),
+			"after":         starlark.String(build.After),
+			"target":        starlark.String(build.Target),
+			"ref":           starlark.String(build.Ref),
+			"commit":        starlark.String(build.After),
+			"title":         starlark.String(build.Title),
+			"message":       starlark.String(build.Message),
+			"source_repo":   starlark.String(build.Fork),
+			"author_login":  starlark.String(build.Author),
+			"author_name":   starlark.String(build.AuthorName),
+			"author_email":  starlark.String(build.AuthorEmail),
+			"author_avatar": starlark.String(build.AuthorAvatar),
+			"sender":        starlark.String(build.Sender),
+			"debug":         starlark.Bool(build.Debug),
+			"cron":          starlark.String(build.Cron),
+			"params":        params,
+		}),
+		"repo": starlarkstruct.FromStringDict(starlark.String("repo"), starlark.StringDict{
+			"uid":                  starlark.String(repo.UID),
+			"name":                 starlark.String(repo.Name),
+			"namespace":            starlark.String(repo.Namespace),
+			"slug":                 starlark.String(repo.Slug),
+			"git_http_url":         starlark.String(repo.HTTPURL),
+			"git_ssh_url":          starlark.String(repo.SSHURL),
+			"link":                 starlark.String(repo.Link),
+			"branch":               starlark.String(repo.Branch),
+			"config":               starlark.String(repo.Config),
+			"private":              starlark.Bool(repo.Private),
+			"visibility":           starlark.String(repo.Visibility),
+			"active":               starlark.Bool(repo.Active),
+			"trusted":              starlark.Bool(repo.Trusted),
+			"protected":            starlark.Bool(repo.Protected),
+			"ignore_forks":         starlark.Bool(repo.IgnoreForks),
+			"ignore_pull_requests": starlark.Bool(repo.IgnorePulls),
+		}),
+		"input": new(starlark.Dict),
+	})
+}
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"sync"
 
+	"github.com/harness/drone-ci-docker-extension/pkg/db/migrations"
 	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/sqlitedialect"
 	"github.com/uptrace/bun/driver/sqliteshim"
 	"github.com/uptrace/bun/extra/bundebug"
+	"github.com/uptrace/bun/migrate"
 )
 
 //Config configures the database to initialize
@@ -80,9 +82,10 @@ func (c *Config) Init() *bun.DB {
 			bundebug.WithVerbose(isVerbose),
 		))
 		c.DB = db
+		db.RegisterModel((*Stage)(nil), (*StageStep)(nil), (*Run)(nil), (*StepRun)(nil))
 
 		//Setup Schema
-		if err := c.createTables(); err != nil {
+		if err := c.migrate(); err != nil {
 			log.Fatal(err)
 		}
 	})
@@ -90,22 +93,32 @@ func (c *Config) Init() *bun.DB {
 	return c.DB
 }
 
-func (c *Config) createTables() error {
-	//Stages
-	if _, err := c.DB.NewCreateTable().
-		Model((*Stage)(nil)).
-		IfNotExists().
-		Exec(c.Ctx); err != nil {
+// migrate applies the pending schema migrations. It refuses to run against a database
+// that has migrations applied which are not known to this version i.e. a newer schema.
+func (c *Config) migrate() error {
+	log := c.Log
+	migrator := migrate.NewMigrator(c.DB, migrations.Migrations)
+	if err := migrator.Init(c.Ctx); err != nil {
 		return err
 	}
-	//Stage Steps
-	if _, err := c.DB.NewCreateTable().
-		Model((*StageStep)(nil)).
-		IfNotExists().
-		ForeignKey(`("stage_id") REFERENCES stages("id") ON DELETE CASCADE`).
-		Exec(c.Ctx); err != nil {
+
+	missing, err := migrator.MissingMigrations(c.Ctx)
+	if err != nil {
 		return err
 	}
+	if len(missing) > 0 {
+		return fmt.Errorf("database %s has a newer schema than supported by this version, unknown migrations %s", c.DBFile, missing)
+	}
+
+	group, err := migrator.Migrate(c.Ctx)
+	if err != nil {
+		return fmt.Errorf("error migrating database %s, %w", c.DBFile, err)
+	}
+	if group.IsZero() {
+		log.Infoln("Database schema is up to date")
+	} else {
+		log.Infof("Migrated database to %s", group)
+	}
 
 	return nil
 }
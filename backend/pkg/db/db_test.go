@@ -3,12 +3,15 @@ package db
 import (
 	"context"
 	"os"
+	"path"
 	"testing"
 
+	"github.com/harness/drone-ci-docker-extension/pkg/db/migrations"
 	"github.com/harness/drone-ci-docker-extension/pkg/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dbfixture"
+	"github.com/uptrace/bun/migrate"
 )
 
 func TestInitDB(t *testing.T) {
@@ -26,7 +29,7 @@ func TestInitDB(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	dbfx := dbfixture.New(dbc.DB, dbfixture.WithRecreateTables())
+	dbfx := dbfixture.New(dbc.DB, dbfixture.WithTruncateTables())
 	if err := dbfx.Load(dbc.Ctx, os.DirFS("."), "testdata/fixtures.yaml"); err != nil {
 		t.Fatal(err)
 	}
@@ -64,3 +67,34 @@ func TestInitDB(t *testing.T) {
 func tearDown() {
 	os.Remove("testdata/test.db")
 }
+
+func TestMigrate(t *testing.T) {
+	log := utils.LogSetup(os.Stdout, "debug")
+	dbc := New(
+		WithContext(context.TODO()),
+		WithDBFile(path.Join(t.TempDir(), "migrate.db")),
+		WithLogger(log))
+
+	dbc.Init()
+
+	migrator := migrate.NewMigrator(dbc.DB, migrations.Migrations)
+	ms, err := migrator.MigrationsWithStatus(dbc.Ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, ms.Unapplied(), "Expecting all migrations to be applied")
+
+	for _, model := range []interface{}{(*Stage)(nil), (*StageStep)(nil), (*Run)(nil), (*StepRun)(nil)} {
+		_, err := dbc.DB.NewSelect().Model(model).Count(dbc.Ctx)
+		assert.NoErrorf(t, err, "Expecting table of %T to exist", model)
+	}
+
+	//re-running is a no-op
+	assert.NoError(t, dbc.migrate())
+
+	//a migration applied by a newer version
+	if err := migrator.MarkApplied(dbc.Ctx, &migrate.Migration{Name: "99991231000000", Comment: "from_the_future"}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, dbc.migrate(), "Expecting migration to fail on newer schema")
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "stage_steps";
+--bun:split
+DROP TABLE IF EXISTS "stages";
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "stages" ("id" INTEGER NOT NULL, "pipeline_file" VARCHAR NOT NULL, "pipeline_path" VARCHAR NOT NULL, "name" VARCHAR NOT NULL, "status" INTEGER NOT NULL, "logs" BLOB, "created_at" TIMESTAMP NOT NULL DEFAULT current_timestamp, "modified_at" TIMESTAMP, PRIMARY KEY ("id"));
+--bun:split
+CREATE UNIQUE INDEX IF NOT EXISTS "pipeline_stage_idx" ON "stages" ("name", "pipeline_file");
+--bun:split
+CREATE TABLE IF NOT EXISTS "stage_steps" ("id" INTEGER NOT NULL, "name" VARCHAR NOT NULL, "image" VARCHAR NOT NULL, "status" INTEGER NOT NULL, "stage_id" INTEGER NOT NULL, "service" INTEGER NOT NULL DEFAULT 0, "created_at" TIMESTAMP NOT NULL DEFAULT current_timestamp, "modified_at" TIMESTAMP, PRIMARY KEY ("id"), FOREIGN KEY ("stage_id") REFERENCES stages("id") ON DELETE CASCADE);
+--bun:split
+CREATE UNIQUE INDEX IF NOT EXISTS "stage_step_idx" ON "stage_steps" ("name", "stage_id");
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "step_runs";
+--bun:split
+DROP TABLE IF EXISTS "runs";
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "runs" ("id" INTEGER NOT NULL, "stage_id" INTEGER NOT NULL, "key" VARCHAR NOT NULL, "status" INTEGER NOT NULL, "includes" VARCHAR, "excludes" VARCHAR, "logs_path" VARCHAR, "started_at" TIMESTAMP, "finished_at" TIMESTAMP, "created_at" TIMESTAMP NOT NULL DEFAULT current_timestamp, "modified_at" TIMESTAMP, PRIMARY KEY ("id"), FOREIGN KEY ("stage_id") REFERENCES stages("id") ON DELETE CASCADE);
+--bun:split
+CREATE UNIQUE INDEX IF NOT EXISTS "stage_run_idx" ON "runs" ("stage_id", "key");
+--bun:split
+CREATE TABLE IF NOT EXISTS "step_runs" ("id" INTEGER NOT NULL, "run_id" INTEGER NOT NULL, "step_id" INTEGER NOT NULL, "step_name" VARCHAR NOT NULL, "status" INTEGER NOT NULL, "exit_code" INTEGER, "log_file" VARCHAR, "started_at" TIMESTAMP, "finished_at" TIMESTAMP, "created_at" TIMESTAMP NOT NULL DEFAULT current_timestamp, "modified_at" TIMESTAMP, PRIMARY KEY ("id"), FOREIGN KEY ("run_id") REFERENCES runs("id") ON DELETE CASCADE);
+--bun:split
+CREATE UNIQUE INDEX IF NOT EXISTS "step_run_idx" ON "step_runs" ("run_id", "step_name");
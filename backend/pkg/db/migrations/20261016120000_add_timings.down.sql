@@ -0,0 +1,11 @@
+ALTER TABLE "stage_steps" DROP COLUMN "duration";
+--bun:split
+ALTER TABLE "stage_steps" DROP COLUMN "finished_at";
+--bun:split
+ALTER TABLE "stage_steps" DROP COLUMN "started_at";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "duration";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "finished_at";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "started_at";
@@ -0,0 +1,11 @@
+ALTER TABLE "stages" ADD COLUMN "started_at" TIMESTAMP;
+--bun:split
+ALTER TABLE "stages" ADD COLUMN "finished_at" TIMESTAMP;
+--bun:split
+ALTER TABLE "stages" ADD COLUMN "duration" INTEGER NOT NULL DEFAULT 0;
+--bun:split
+ALTER TABLE "stage_steps" ADD COLUMN "started_at" TIMESTAMP;
+--bun:split
+ALTER TABLE "stage_steps" ADD COLUMN "finished_at" TIMESTAMP;
+--bun:split
+ALTER TABLE "stage_steps" ADD COLUMN "duration" INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+ALTER TABLE "stage_steps" DROP COLUMN "when";
+--bun:split
+ALTER TABLE "stage_steps" DROP COLUMN "depends_on";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "platform";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "trigger";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "depends_on";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "type";
+--bun:split
+ALTER TABLE "stages" DROP COLUMN "kind";
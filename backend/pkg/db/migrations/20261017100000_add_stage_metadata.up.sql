@@ -0,0 +1,13 @@
+ALTER TABLE "stages" ADD COLUMN "kind" VARCHAR NOT NULL DEFAULT 'pipeline';
+--bun:split
+ALTER TABLE "stages" ADD COLUMN "type" VARCHAR NOT NULL DEFAULT 'docker';
+--bun:split
+ALTER TABLE "stages" ADD COLUMN "depends_on" VARCHAR;
+--bun:split
+ALTER TABLE "stages" ADD COLUMN "trigger" VARCHAR;
+--bun:split
+ALTER TABLE "stages" ADD COLUMN "platform" VARCHAR;
+--bun:split
+ALTER TABLE "stage_steps" ADD COLUMN "depends_on" VARCHAR;
+--bun:split
+ALTER TABLE "stage_steps" ADD COLUMN "when" VARCHAR;
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"embed"
+
+	"github.com/uptrace/bun/migrate"
+)
+
+// Migrations are the versioned schema migrations of the database. Each migration is a pair of
+// SQL scripts named <version>_<name>.up.sql and <version>_<name>.down.sql, the scripts are
+// applied in the order of their version.
+var Migrations = migrate.NewMigrations()
+
+//go:embed *.sql
+var sqlMigrations embed.FS
+
+func init() {
+	if err := Migrations.Discover(sqlMigrations); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// StopStage marks the stage, the given runs of it and the steps that are yet to finish as Stopped
+// in a single transaction. The steps that were stopped are returned.
+func StopStage(ctx context.Context, dbConn *bun.DB, stageID int, runIDs []int) (Steps, error) {
+	steps := make(Steps, 0)
+	finishedAt := time.Now()
+	unfinished := bun.In([]Status{None, Running})
+	err := dbConn.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		if err := tx.NewSelect().
+			Model(&steps).
+			Where("stage_id = ? AND status IN (?)", stageID, unfinished).
+			Scan(ctx); err != nil {
+			return err
+		}
+
+		if _, err := tx.NewUpdate().
+			Model((*StageStep)(nil)).
+			Set("status = ?", Stopped).
+			Where("stage_id = ? AND status IN (?)", stageID, unfinished).
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		if _, err := tx.NewUpdate().
+			Model((*Stage)(nil)).
+			Set("status = ?", Stopped).
+			Where("id = ?", stageID).
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		if len(runIDs) == 0 {
+			return nil
+		}
+
+		if _, err := tx.NewUpdate().
+			Model((*StepRun)(nil)).
+			Set("status = ?", Stopped).
+			Set("finished_at = ?", finishedAt).
+			Where("run_id IN (?) AND status = ?", bun.In(runIDs), Running).
+			Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err := tx.NewUpdate().
+			Model((*Run)(nil)).
+			Set("status = ?", Stopped).
+			Set("finished_at = ?", finishedAt).
+			Where("id IN (?) AND status = ?", bun.In(runIDs), Running).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return steps, nil
+}
@@ -21,6 +21,8 @@ const (
 	Error
 	//4 represents stopped/cancelled
 	Stopped
+	//5 represents skipped stage/step whose trigger/when conditions are not met
+	Skipped
 )
 
 func (s Status) String() string {
@@ -33,24 +35,58 @@ func (s Status) String() string {
 		return "error"
 	case 4:
 		return "stopped"
+	case 5:
+		return "skipped"
 	default:
 		return "none"
 	}
 }
 
+// Condition is the values a trigger or when condition includes and excludes e.g. the branches
+type Condition struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Conditions are the conditions of a stage trigger or a step when keyed by their name e.g. event
+type Conditions map[string]Condition
+
+// Platform is the platform a stage runs on
+type Platform struct {
+	OS      string `json:"os,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
 // Stage represents Drone Stage
 type Stage struct {
 	bun.BaseModel `bun:"table:stages,alias:s"`
 
-	ID           int       `bun:",pk,autoincrement" json:"id"`
-	PipelineFile string    `bun:",notnull" json:"pipelineFile"`
-	PipelinePath string    `bun:",notnull" json:"pipelinePath"`
-	Name         string    `bun:",notnull" json:"name"`
-	Status       Status    `bun:",notnull" json:"status"`
-	Steps        Steps     `bun:"rel:has-many,join:id=stage_id" json:"steps"`
-	Logs         []byte    `json:"logs"`
-	CreatedAt    time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"-"`
-	ModifiedAt   time.Time `json:"-"`
+	ID           int    `bun:",pk,autoincrement" json:"id"`
+	PipelineFile string `bun:",notnull" json:"pipelineFile"`
+	PipelinePath string `bun:",notnull" json:"pipelinePath"`
+	Name         string `bun:",notnull" json:"name"`
+	Status       Status `bun:",notnull" json:"status"`
+	Steps        Steps  `bun:"rel:has-many,join:id=stage_id" json:"steps"`
+	Logs         []byte `json:"logs"`
+	//Kind is the kind of the pipeline document, always pipeline for the stages
+	Kind string `bun:",nullzero,notnull,default:'pipeline'" json:"kind"`
+	//Type is the runner of the stage e.g. docker
+	Type string `bun:",nullzero,notnull,default:'docker'" json:"type"`
+	//DependsOn are the names of the stages of the same pipeline file this stage runs after
+	DependsOn []string `json:"dependsOn,omitempty"`
+	//Trigger are the conditions for the stage to run
+	Trigger  Conditions `json:"trigger,omitempty"`
+	Platform *Platform  `json:"platform,omitempty"`
+	//StartedAt is the time the first step of the latest run started
+	StartedAt time.Time `bun:",nullzero" json:"startedAt"`
+	//FinishedAt is the time the latest run finished
+	FinishedAt time.Time `bun:",nullzero" json:"finishedAt"`
+	//Duration is the total time taken by the latest run in milliseconds
+	Duration   int64     `bun:",notnull" json:"duration"`
+	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"-"`
+	ModifiedAt time.Time `json:"-"`
 }
 
 // StageStep represents Stage step
@@ -63,13 +99,64 @@ type StageStep struct {
 	Status  Status `bun:",notnull" json:"status"`
 	StageID int    `bun:",notnull" json:"stageId"`
 	//Flag to indicate if Step is a Service
-	Service    int       `bun:",nullzero,notnull,default:0" json:"isService"`
+	Service int `bun:",nullzero,notnull,default:0" json:"isService"`
+	//DependsOn are the names of the steps this step runs after
+	DependsOn []string `json:"dependsOn,omitempty"`
+	//When are the conditions for the step to run
+	When       Conditions `json:"when,omitempty"`
+	StartedAt  time.Time  `bun:",nullzero" json:"startedAt"`
+	FinishedAt time.Time  `bun:",nullzero" json:"finishedAt"`
+	//Duration is the time taken by the step in milliseconds
+	Duration   int64     `bun:",notnull" json:"duration"`
+	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"-"`
+	ModifiedAt time.Time `json:"-"`
+}
+
+// Run represents an execution of a Stage
+type Run struct {
+	bun.BaseModel `bun:"table:runs,alias:r"`
+
+	ID      int `bun:",pk,autoincrement" json:"id"`
+	StageID int `bun:",notnull" json:"stageId"`
+	//Key uniquely identifies the execution, shared by all the step containers of the run
+	Key    string `bun:",notnull" json:"key"`
+	Status Status `bun:",notnull" json:"status"`
+	//Includes are the steps that were included in the run
+	Includes []string `json:"includes"`
+	//Excludes are the steps that were excluded from the run
+	Excludes []string `json:"excludes"`
+	//LogsPath is the directory holding the logs of the run steps
+	LogsPath   string    `json:"logsPath"`
+	Steps      StepRuns  `bun:"rel:has-many,join:id=run_id" json:"steps"`
+	StartedAt  time.Time `bun:",nullzero" json:"startedAt"`
+	FinishedAt time.Time `bun:",nullzero" json:"finishedAt"`
+	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"-"`
+	ModifiedAt time.Time `json:"-"`
+}
+
+// StepRun represents the execution of a Stage step as part of a Run
+type StepRun struct {
+	bun.BaseModel `bun:"table:step_runs,alias:sr"`
+
+	ID       int    `bun:",pk,autoincrement" json:"id"`
+	RunID    int    `bun:",notnull" json:"runId"`
+	StepID   int    `bun:",notnull" json:"stepId"`
+	StepName string `bun:",notnull" json:"stepName"`
+	Status   Status `bun:",notnull" json:"status"`
+	//ExitCode of the step container, nil until the step is done
+	ExitCode *int `json:"exitCode"`
+	//LogFile is the file holding the logs of the step
+	LogFile    string    `json:"logFile"`
+	StartedAt  time.Time `bun:",nullzero" json:"startedAt"`
+	FinishedAt time.Time `bun:",nullzero" json:"finishedAt"`
 	CreatedAt  time.Time `bun:",nullzero,notnull,default:current_timestamp" json:"-"`
 	ModifiedAt time.Time `json:"-"`
 }
 
 type Stages []*Stage
 type Steps []*StageStep
+type Runs []*Run
+type StepRuns []*StepRun
 
 var _ sort.Interface = (Stages)(nil)
 var _ sort.Interface = (Steps)(nil)
@@ -0,0 +1,132 @@
+package drone
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// CompileCommand exports the compile command, the same as exec with dry-run.
+var CompileCommand = &cli.Command{
+	Name:      "compile",
+	Usage:     "compile a local build to the engine spec without executing it",
+	ArgsUsage: "[path/to/.drone.yml|.drone.jsonnet|.drone.star]",
+	Action: func(ctx *cli.Context) error {
+		if err := compile(ctx); err != nil {
+			log.Fatalln(err)
+		}
+		return nil
+	},
+	Flags: append([]cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "format of the engine spec, json or yaml",
+			Value: "json",
+		},
+	}, execFlags...),
+}
+
+func compile(cliContext *cli.Context) error {
+	// the spec is written to the stdout, keep the logs out of it
+	log := utils.LogSetup(os.Stderr, logrus.InfoLevel.String())
+	commy := toExecCommand(cliContext)
+	return commy.dryRun(cliContext.App.Writer, log, cliContext.String("format"))
+}
+
+// dryRun compiles the pipeline stage, or all the stages with the All flag, and writes the
+// engine specs in the format to w. The stages whose trigger conditions are not met are left out.
+func (commy *execCommand) dryRun(w io.Writer, log *logrus.Logger, format string) error {
+	if commy.Debug {
+		log.SetLevel(logrus.DebugLevel)
+	}
+	if commy.Trace {
+		log.SetLevel(logrus.TraceLevel)
+	}
+
+	if !commy.All {
+		spec, err := commy.compile(log)
+		if errors.Is(err, errStageSkipped) {
+			log.Infof("Skipping stage %s, the trigger conditions are not met", commy.Stage.Name)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return writeSpec(w, format, redactSpec(spec))
+	}
+
+	m, err := commy.parse()
+	if err != nil {
+		return err
+	}
+	nodes, err := stageGraph(m)
+	if err != nil {
+		return err
+	}
+	specs := make(map[string]*engine.Spec, len(nodes))
+	for _, node := range nodes {
+		spec, err := commy.forStage(node).compile(log)
+		if errors.Is(err, errStageSkipped) {
+			log.Infof("Skipping stage %s, the trigger conditions are not met", node.name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("stage %s: %w", node.name, err)
+		}
+		specs[node.name] = redactSpec(spec)
+	}
+	return writeSpec(w, format, specs)
+}
+
+// redactSpec leaves out the values of the masked secrets and the registry passwords from the spec
+func redactSpec(spec *engine.Spec) *engine.Spec {
+	for _, steps := range [][]*engine.Step{spec.Steps, spec.Internal} {
+		for _, step := range steps {
+			for _, sec := range step.Secrets {
+				if sec.Mask {
+					sec.Data = nil
+				}
+			}
+			if step.Auth != nil {
+				step.Auth.Password = ""
+			}
+		}
+	}
+	return spec
+}
+
+// writeSpec writes v as indented JSON or as YAML, the YAML uses the same field names as the JSON
+func writeSpec(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		var out interface{}
+		if err := json.Unmarshal(b, &out); err != nil {
+			return err
+		}
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+		return enc.Close()
+	default:
+		return fmt.Errorf("unknown format %s, the format could be json or yaml", format)
+	}
+}
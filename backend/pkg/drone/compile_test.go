@@ -0,0 +1,96 @@
+package drone
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+const compilePipeline = `
+kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: build
+  image: golang:${GO_VERSION=1.19}
+  environment:
+    TOKEN:
+      from_secret: token
+  commands:
+  - go build ./...
+- name: publish
+  image: plugins/docker
+`
+
+func TestDryRun(t *testing.T) {
+	type step struct {
+		Name      string            `json:"name" yaml:"name"`
+		Image     string            `json:"image" yaml:"image"`
+		RunPolicy string            `json:"run_policy" yaml:"run_policy"`
+		Labels    map[string]string `json:"labels" yaml:"labels"`
+		Secrets   []struct {
+			Env  string `json:"env" yaml:"env"`
+			Data []byte `json:"data" yaml:"data"`
+		} `json:"secrets" yaml:"secrets"`
+	}
+	type spec struct {
+		Steps []step `json:"steps" yaml:"steps"`
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		t.Run(format, func(t *testing.T) {
+			commy := Options{
+				PipelineFile: "/tmp/examples/compile/.drone.yml",
+				Config:       []byte(compilePipeline),
+				Stage:        "default",
+				Exclude:      []string{"publish"},
+			}.toExecCommand()
+			commy.SecretSources = []secretSource{staticSecrets{"token": "s3cr3t"}}
+
+			var out bytes.Buffer
+			if !assert.NoError(t, commy.dryRun(&out, logrus.New(), format)) {
+				return
+			}
+			var got spec
+			if format == "json" {
+				assert.NoError(t, json.Unmarshal(out.Bytes(), &got))
+			} else {
+				assert.NoError(t, yaml.Unmarshal(out.Bytes(), &got))
+			}
+			assert.NotContains(t, out.String(), "s3cr3t")
+
+			steps := make(map[string]step)
+			for _, s := range got.Steps {
+				steps[s.Name] = s
+			}
+			if assert.Contains(t, steps, "build") {
+				assert.Equal(t, "docker.io/library/golang:1.19", steps["build"].Image)
+				// the default on-success run policy is left out
+				assert.Empty(t, steps["build"].RunPolicy)
+				assert.Equal(t, "default", steps["build"].Labels["io.drone.stage.name"])
+				if assert.Len(t, steps["build"].Secrets, 1) {
+					assert.Equal(t, "TOKEN", steps["build"].Secrets[0].Env)
+					assert.Empty(t, steps["build"].Secrets[0].Data)
+				}
+			}
+			if assert.Contains(t, steps, "publish") {
+				assert.Equal(t, "never", steps["publish"].RunPolicy)
+			}
+		})
+	}
+
+	t.Run("unknownFormat", func(t *testing.T) {
+		commy := Options{
+			PipelineFile: "/tmp/examples/compile/.drone.yml",
+			Config:       []byte(compilePipeline),
+			Stage:        "default",
+		}.toExecCommand()
+		commy.SecretSources = []secretSource{staticSecrets{"token": "s3cr3t"}}
+		assert.EqualError(t, commy.dryRun(&bytes.Buffer{}, logrus.New(), "toml"), "unknown format toml, the format could be json or yaml")
+	})
+}
@@ -0,0 +1,195 @@
+package drone
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/sirupsen/logrus"
+)
+
+// stageNode is a pipeline of the multi-stage pipeline file along with the pipelines it depends on
+type stageNode struct {
+	name    string
+	number  int
+	deps    []string
+	trigger manifest.Conditions
+}
+
+// stageGraph gets the docker pipelines of the manifest in the order they are defined, an error is
+// returned when a pipeline depends on an unknown pipeline or when the dependencies form a cycle
+func stageGraph(m *manifest.Manifest) ([]*stageNode, error) {
+	nodes := make([]*stageNode, 0)
+	byName := make(map[string]*stageNode)
+	for _, res := range m.Resources {
+		p, ok := res.(*resource.Pipeline)
+		if !ok {
+			continue
+		}
+		if _, ok := byName[p.Name]; ok {
+			return nil, fmt.Errorf("duplicate pipeline %q", p.Name)
+		}
+		node := &stageNode{
+			name:    p.Name,
+			number:  len(nodes) + 1,
+			deps:    p.Deps,
+			trigger: p.Trigger,
+		}
+		nodes = append(nodes, node)
+		byName[p.Name] = node
+	}
+
+	for _, node := range nodes {
+		for _, dep := range node.deps {
+			if _, ok := byName[dep]; !ok {
+				return nil, fmt.Errorf("pipeline %q depends on unknown pipeline %q", node.name, dep)
+			}
+		}
+	}
+
+	// depth first search for the cycles, the path is kept to report the cycle
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(node *stageNode) error
+	visit = func(node *stageNode) error {
+		switch state[node.name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("pipeline dependency cycle %s -> %s", strings.Join(path, " -> "), node.name)
+		}
+		state[node.name] = visiting
+		path = append(path, node.name)
+		for _, dep := range node.deps {
+			if err := visit(byName[dep]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[node.name] = visited
+		return nil
+	}
+	for _, node := range nodes {
+		if err := visit(node); err != nil {
+			return nil, err
+		}
+	}
+
+	return nodes, nil
+}
+
+// shouldRun decides whether the stage runs once the stages it depends on are done. The stage runs
+// only when all of them passed, unless its trigger has a status condition e.g. `status: [failure]`.
+func (node *stageNode) shouldRun(depStates map[string]*pipeline.State) bool {
+	status := drone.StatusPassing
+	for _, dep := range node.deps {
+		switch depStates[dep].Stage.Status {
+		case drone.StatusPassing, drone.StatusSkipped:
+		default:
+			status = drone.StatusFailing
+		}
+	}
+	if len(node.trigger.Status.Include) == 0 && len(node.trigger.Status.Exclude) == 0 {
+		return status == drone.StatusPassing
+	}
+	return node.trigger.Status.Match(status)
+}
+
+// forStage returns a copy of the command to execute the named stage, the stages share the
+// build, repo and system but not the stage
+func (commy *execCommand) forStage(node *stageNode) *execCommand {
+	flags := *commy.Flags
+	flags.Stage = &drone.Stage{
+		Name:   node.name,
+		Number: node.number,
+	}
+	stageCommy := *commy
+	stageCommy.Flags = &flags
+	return &stageCommy
+}
+
+// runAll executes all the pipelines of the pipeline file in the order of their depends_on. The
+// pipelines that are independent of each other run in parallel, the pipelines that depend on a
+// failed pipeline are skipped. The states of the pipelines are returned by the pipeline name.
+func (commy *execCommand) runAll(ctx context.Context, log *logrus.Logger) (map[string]*pipeline.State, error) {
+	m, err := commy.parse()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := stageGraph(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	states := make(map[string]*pipeline.State, len(nodes))
+	done := make(map[string]chan struct{}, len(nodes))
+	for _, node := range nodes {
+		done[node.name] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	var errs []string
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *stageNode) {
+			defer wg.Done()
+			defer close(done[node.name])
+
+			for _, dep := range node.deps {
+				<-done[dep]
+			}
+
+			stageCommy := commy.forStage(node)
+			mu.Lock()
+			run := ctx.Err() == nil && node.shouldRun(states)
+			mu.Unlock()
+
+			var state *pipeline.State
+			if run {
+				log.Infof("Running stage %s", node.name)
+				var runErr error
+				state, runErr = stageCommy.run(ctx, log)
+				if runErr != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Sprintf("stage %s: %s", node.name, runErr))
+					mu.Unlock()
+				}
+			} else {
+				log.Infof("Skipping stage %s, the stages it depends on did not pass", node.name)
+				stageCommy.Stage.Status = drone.StatusSkipped
+			}
+			// the stages that could not be compiled or run are reported as errored
+			if state == nil {
+				state = &pipeline.State{
+					Build:  stageCommy.Build,
+					Stage:  stageCommy.Stage,
+					Repo:   stageCommy.Repo,
+					System: stageCommy.System,
+				}
+				if stageCommy.Stage.Status == "" {
+					stageCommy.Stage.Status = drone.StatusError
+				}
+			}
+
+			mu.Lock()
+			states[node.name] = state
+			mu.Unlock()
+		}(node)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return states, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return states, nil
+}
@@ -0,0 +1,165 @@
+package drone
+
+import (
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+const multiStagePipeline = `
+kind: pipeline
+type: docker
+name: build
+
+steps:
+- name: build
+  image: busybox
+
+---
+kind: pipeline
+type: docker
+name: test
+
+steps:
+- name: test
+  image: busybox
+
+---
+kind: pipeline
+type: docker
+name: publish
+
+steps:
+- name: publish
+  image: busybox
+
+depends_on:
+- build
+- test
+
+---
+kind: pipeline
+type: docker
+name: notify
+
+steps:
+- name: notify
+  image: busybox
+
+trigger:
+  status:
+  - failure
+
+depends_on:
+- publish
+`
+
+func TestStageGraph(t *testing.T) {
+	graphTests := map[string]struct {
+		config    string
+		wantDeps  map[string][]string
+		wantError string
+	}{
+		"multiStage": {
+			config: multiStagePipeline,
+			wantDeps: map[string][]string{
+				"build":   nil,
+				"test":    nil,
+				"publish": {"build", "test"},
+				"notify":  {"publish"},
+			},
+		},
+		"unknownDependency": {
+			config: `
+kind: pipeline
+type: docker
+name: publish
+
+depends_on:
+- build
+`,
+			wantError: `pipeline "publish" depends on unknown pipeline "build"`,
+		},
+		"cycle": {
+			config: `
+kind: pipeline
+type: docker
+name: build
+
+depends_on:
+- publish
+
+---
+kind: pipeline
+type: docker
+name: test
+
+depends_on:
+- build
+
+---
+kind: pipeline
+type: docker
+name: publish
+
+depends_on:
+- test
+`,
+			wantError: "pipeline dependency cycle build -> publish -> test -> build",
+		},
+	}
+
+	for name, tc := range graphTests {
+		t.Run(name, func(t *testing.T) {
+			m, err := manifest.ParseString(tc.config)
+			if !assert.NoError(t, err) {
+				return
+			}
+			nodes, err := stageGraph(m)
+			if tc.wantError != "" {
+				assert.EqualError(t, err, tc.wantError)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			gotDeps := make(map[string][]string)
+			for i, node := range nodes {
+				assert.Equal(t, i+1, node.number)
+				gotDeps[node.name] = node.deps
+			}
+			assert.Equal(t, tc.wantDeps, gotDeps)
+		})
+	}
+}
+
+func TestShouldRun(t *testing.T) {
+	m, err := manifest.ParseString(multiStagePipeline)
+	if !assert.NoError(t, err) {
+		return
+	}
+	nodes, err := stageGraph(m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	publish, notify := nodes[2], nodes[3]
+
+	states := func(statuses map[string]string) map[string]*pipeline.State {
+		s := make(map[string]*pipeline.State)
+		for name, status := range statuses {
+			s[name] = &pipeline.State{Stage: &drone.Stage{Name: name, Status: status}}
+		}
+		return s
+	}
+
+	assert.True(t, publish.shouldRun(states(map[string]string{"build": drone.StatusPassing, "test": drone.StatusPassing})))
+	assert.True(t, publish.shouldRun(states(map[string]string{"build": drone.StatusPassing, "test": drone.StatusSkipped})))
+	assert.False(t, publish.shouldRun(states(map[string]string{"build": drone.StatusPassing, "test": drone.StatusFailing})))
+	assert.False(t, publish.shouldRun(states(map[string]string{"build": drone.StatusError, "test": drone.StatusPassing})))
+
+	assert.False(t, notify.shouldRun(states(map[string]string{"publish": drone.StatusPassing})))
+	assert.True(t, notify.shouldRun(states(map[string]string{"publish": drone.StatusFailing})))
+}
@@ -3,43 +3,36 @@ package drone
 import (
 	"os"
 	"strings"
-
-	"github.com/urfave/cli/v2"
 )
 
-func getEnv(c *cli.Context) map[string]string {
+// getEnv gets the DRONE_ environment variables, overridden by the build details
+// from the flags or the local git checkout
+func getEnv(flags *Flags) map[string]string {
 	env := prefixedEnviron(
 		os.Environ(),
 	)
-	if c.IsSet("branch") {
-		v := c.String("branch")
+	if v := flags.Build.Target; v != "" {
 		env["DRONE_BRANCH"] = v
 		env["DRONE_COMMIT_BRANCH"] = v
 		env["DRONE_TARGET_BRANCH"] = v
 	}
-	if c.IsSet("event") {
-		v := c.String("event")
+	if v := flags.Build.Event; v != "" {
 		env["DRONE_EVENT"] = v
 	}
-	if c.IsSet("instance") {
-		v := c.String("instance")
+	if v := flags.System.Host; v != "" {
 		env["DRONE_SYSTEM_HOST"] = v
 		env["DRONE_SYSTEM_HOSTNAME"] = v
 	}
-	if c.IsSet("ref") {
-		v := c.String("ref")
+	if v := flags.Build.Ref; v != "" {
 		env["DRONE_COMMIT_REF"] = v
 	}
-	if c.IsSet("sha") {
-		v := c.String("sha")
+	if v := flags.Build.After; v != "" {
 		env["DRONE_COMMIT_SHA"] = v
 	}
-	if c.IsSet("repo") {
-		v := c.String("repo")
+	if v := flags.Repo.Slug; v != "" {
 		env["DRONE_REPO"] = v
 	}
-	if c.IsSet("deploy-to") {
-		v := c.String("deploy-to")
+	if v := flags.Build.Deploy; v != "" {
 		env["DRONE_DEPLOY_TO"] = v
 	}
 	return env
@@ -3,11 +3,12 @@ package drone
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"github.com/drone-runners/drone-runner-docker/engine/compiler"
 	"github.com/drone-runners/drone-runner-docker/engine/linter"
 	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/harness/drone-ci-docker-extension/pkg/converter"
 	"github.com/harness/drone-ci-docker-extension/pkg/monitor"
 	"github.com/harness/drone-ci-docker-extension/pkg/utils"
 
@@ -39,88 +41,255 @@ import (
 
 var nocontext = context.Background()
 
+// defaultPrivileged are the plugins that are run in privileged mode by default
+var defaultPrivileged = []string{
+	"plugins/docker",
+	"plugins/acr",
+	"plugins/ecr",
+	"plugins/gcr",
+	"plugins/heroku",
+}
+
+// execFlags are the flags to build the execCommand, shared by the exec and compile commands
+var execFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "pipeline",
+		Usage: "Name of the pipeline to execute",
+	},
+	&cli.StringSliceFlag{
+		Name:  "include",
+		Usage: "Name of steps to include",
+	},
+	&cli.StringSliceFlag{
+		Name:  "exclude",
+		Usage: "Name of steps to exclude",
+	},
+	&cli.BoolFlag{
+		Name:  "all",
+		Usage: "execute all the pipelines in the order of their depends_on, the pipeline flag is ignored",
+	},
+	&cli.StringFlag{
+		Name:  "resume-at",
+		Usage: "Name of the step to resume at, the steps before it are skipped",
+	},
+	&cli.BoolFlag{
+		Name:  "trusted",
+		Usage: "build is trusted",
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "build timeout",
+		Value: time.Hour,
+	},
+	&cli.StringSliceFlag{
+		Name:  "volume",
+		Usage: "build volumes",
+	},
+	&cli.StringSliceFlag{
+		Name:  "network",
+		Usage: "external networks",
+	},
+	&cli.StringFlag{
+		Name:  "registry",
+		Usage: "registry file",
+	},
+	&cli.StringFlag{
+		Name:    "docker-config",
+		Usage:   "docker config file with the registry credentials of docker login, defaults to ~/.docker/config.json",
+		EnvVars: []string{"DRONE_DOCKER_CONFIG"},
+	},
+	&cli.StringFlag{
+		Name:  "env-file",
+		Usage: "env file",
+	},
+	&cli.StringSliceFlag{
+		Name:  "privileged",
+		Usage: "privileged plugins",
+		Value: cli.NewStringSlice(defaultPrivileged...),
+	},
+	&cli.StringFlag{
+		Name:    "event",
+		Usage:   "build event e.g. push, pull_request, tag, promote",
+		EnvVars: []string{"DRONE_EVENT"},
+		Value:   "push",
+	},
+	&cli.StringFlag{
+		Name:    "ref",
+		Usage:   "git commit ref, defaults to the ref of the local git checkout",
+		EnvVars: []string{"DRONE_COMMIT_REF"},
+	},
+	&cli.StringFlag{
+		Name:    "branch",
+		Usage:   "git commit branch, defaults to the branch of the local git checkout",
+		EnvVars: []string{"DRONE_BRANCH"},
+	},
+	&cli.StringFlag{
+		Name:    "sha",
+		Usage:   "git commit sha, defaults to the sha of the local git checkout",
+		EnvVars: []string{"DRONE_COMMIT_SHA"},
+	},
+	&cli.StringFlag{
+		Name:    "repo",
+		Usage:   "git repository name e.g. octocat/hello-world",
+		EnvVars: []string{"DRONE_REPO"},
+	},
+	&cli.StringFlag{
+		Name:  "name",
+		Usage: "git repository short name, defaults to the name in the repo flag",
+	},
+	&cli.StringFlag{
+		Name:    "deploy-to",
+		Usage:   "build deployment target e.g. production, for promote events",
+		EnvVars: []string{"DRONE_DEPLOY_TO"},
+	},
+	&cli.StringFlag{
+		Name:    "instance",
+		Usage:   "drone instance hostname",
+		EnvVars: []string{"DRONE_SYSTEM_HOST"},
+	},
+	&cli.BoolFlag{
+		Name:  "clone",
+		Usage: "enable the clone step instead of mounting the current working directory",
+	},
+	&cli.StringFlag{
+		Name:  "netrc-username",
+		Usage: "netrc username used by the clone step",
+	},
+	&cli.StringFlag{
+		Name:  "netrc-password",
+		Usage: "netrc password used by the clone step",
+	},
+	&cli.StringFlag{
+		Name:  "netrc-machine",
+		Usage: "netrc machine used by the clone step",
+	},
+	&cli.BoolFlag{
+		Name:  "debug",
+		Usage: "enable debug logging",
+	},
+	&cli.BoolFlag{
+		Name:  "trace",
+		Usage: "enable trace logging",
+	},
+	&cli.BoolFlag{
+		Name:  "pretty",
+		Usage: "enable pretty printing of the step logs",
+	},
+	&cli.StringFlag{
+		Name:  "output",
+		Usage: "output format of the run, text or json for newline delimited json events",
+		Value: "text",
+	},
+}, secretFlags...)
+
 // Command exports the exec command.
 var Command = &cli.Command{
 	Name:      "exec",
 	Usage:     "execute a local build",
-	ArgsUsage: "[path/to/.drone.yml]",
+	ArgsUsage: "[path/to/.drone.yml|.drone.jsonnet|.drone.star]",
 	Action: func(ctx *cli.Context) error {
 		if err := exec(ctx); err != nil {
 			log.Fatalln(err)
 		}
 		return nil
 	},
-	Flags: []cli.Flag{
-		&cli.StringFlag{
-			Name:  "pipeline",
-			Usage: "Name of the pipeline to execute",
-		},
-		&cli.StringSliceFlag{
-			Name:  "include",
-			Usage: "Name of steps to include",
-		},
-		&cli.StringSliceFlag{
-			Name:  "exclude",
-			Usage: "Name of steps to exclude",
-		},
-		&cli.StringFlag{
-			Name:  "resume-at",
-			Usage: "Name of start to resume at",
-		},
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
-			Name:  "trusted",
-			Usage: "build is trusted",
-		},
-		&cli.DurationFlag{
-			Name:  "timeout",
-			Usage: "build timeout",
-			Value: time.Hour,
-		},
-		&cli.StringSliceFlag{
-			Name:  "volume",
-			Usage: "build volumes",
-		},
-		&cli.StringSliceFlag{
-			Name:  "network",
-			Usage: "external networks",
-		},
-		&cli.StringFlag{
-			Name:  "registry",
-			Usage: "registry file",
-		},
-		&cli.StringFlag{
-			Name:    "secret-file",
-			Aliases: []string{"secrets"},
-			Usage:   "secret file, define values that can be used with from_secret",
+			Name:  "dry-run",
+			Usage: "compile the pipeline and print the engine spec without executing it",
 		},
 		&cli.StringFlag{
-			Name:  "env-file",
-			Usage: "env file",
-		},
-		&cli.StringSliceFlag{
-			Name:  "privileged",
-			Usage: "privileged plugins",
-			Value: cli.NewStringSlice(
-				"plugins/docker",
-				"plugins/acr",
-				"plugins/ecr",
-				"plugins/gcr",
-				"plugins/heroku",
-			),
+			Name:  "format",
+			Usage: "format of the engine spec printed with dry-run, json or yaml",
+			Value: "json",
 		},
-	},
+	}, execFlags...),
 }
 
 func exec(cliContext *cli.Context) error {
-	log := utils.LogSetup(os.Stdout, logrus.DebugLevel.String())
+	if cliContext.Bool("dry-run") {
+		return compile(cliContext)
+	}
 	// lets do our mapping from CLI flags to an execCommand struct
 	commy := toExecCommand(cliContext)
-	rawsource, err := ioutil.ReadFile(commy.Source)
+	logOut := os.Stdout
+	switch commy.Output {
+	case "text":
+	case "json":
+		// the events are written to the stdout, keep the logs out of it
+		logOut = os.Stderr
+		commy.output = newJSONOutput(os.Stdout)
+	default:
+		return fmt.Errorf("unknown output %s, the output could be text or json", commy.Output)
+	}
+	log := utils.LogSetup(logOut, logrus.DebugLevel.String())
+
+	ctx, cancel := context.WithCancel(nocontext)
+	defer cancel()
+
+	// listen for operating system signals and cancel execution when received.
+	ctx = signal.WithContextFunc(ctx, func() {
+		println("received signal, terminating process")
+		cancel()
+	})
+
+	if commy.All {
+		states, err := commy.runAll(ctx, log)
+		if commy.output != nil {
+			for _, state := range states {
+				commy.dump(state)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		for _, state := range states {
+			switch state.Stage.Status {
+			case drone.StatusError, drone.StatusFailing, drone.StatusKilled:
+				os.Exit(1)
+			}
+		}
+		return nil
+	}
+
+	state, err := commy.run(ctx, log)
 	if err != nil {
+		if state != nil {
+			commy.dump(state)
+		}
 		return err
 	}
+	if commy.output != nil {
+		commy.dump(state)
+	}
+	switch state.Stage.Status {
+	case drone.StatusError, drone.StatusFailing, drone.StatusKilled:
+		os.Exit(1)
+	}
+	return nil
+}
+
+// parse reads the pipeline file and parses it to the manifest once the
+// config is converted to YAML and the environment variables are substituted.
+func (commy *execCommand) parse() (*manifest.Manifest, error) {
+	rawsource := commy.RawSource
+	if rawsource == nil {
+		var err error
+		if rawsource, err = ioutil.ReadFile(commy.Source); err != nil {
+			return nil, err
+		}
+	}
+	// the Jsonnet and Starlark configs are rendered to YAML before the substitutions,
+	// the same as the drone server does
+	rawsource, err := converter.Convert(commy.Source, rawsource, &converter.Context{
+		Build: commy.Build,
+		Repo:  commy.Repo,
+	})
+	if err != nil {
+		return nil, err
+	}
 	envs := environ.Combine(
-		getEnv(cliContext),
+		commy.Envs,
 		environ.System(commy.System),
 		environ.Repo(commy.Repo),
 		environ.Build(commy.Build),
@@ -144,13 +313,20 @@ func exec(cliContext *cli.Context) error {
 	// update configuration.
 	config, err := envsubst.Eval(string(rawsource), subf)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	// parse the configuration.
+	return manifest.ParseString(config)
+}
+
+// compile parses, lints and compiles the pipeline stage to the engine specification
+// that is ready to be executed.
+func (commy *execCommand) compile(log *logrus.Logger) (*engine.Spec, error) {
 	// parse and lint the configuration.
-	manifest, err := manifest.ParseString(config)
+	manifest, err := commy.parse()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// a configuration can contain multiple pipelines.
@@ -161,7 +337,7 @@ func exec(cliContext *cli.Context) error {
 
 	res, err := resource.Lookup(commy.Stage.Name, manifest)
 	if err != nil {
-		return fmt.Errorf("stage '%s' not found in build file : %w", commy.Stage.Name, err)
+		return nil, fmt.Errorf("stage '%s' not found in build file : %w", commy.Stage.Name, err)
 	}
 
 	// lint the pipeline and return an error if any
@@ -169,7 +345,21 @@ func exec(cliContext *cli.Context) error {
 	lint := linter.New()
 	err = lint.Lint(res, commy.Repo)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	//Handle to parsed Pipeline
+	p := res.(*resource.Pipeline)
+
+	// skip the stage when the trigger conditions are not met, the same as the
+	// drone server does for the build
+	if !p.Trigger.Match(commy.match()) {
+		return nil, errStageSkipped
+	}
+
+	secrets, err := loadSecrets(nocontext, commy.SecretSources)
+	if err != nil {
+		return nil, err
 	}
 
 	// compile the pipeline to an intermediate representation.
@@ -181,9 +371,11 @@ func exec(cliContext *cli.Context) error {
 		Privileged: append(commy.Privileged, compiler.Privileged...),
 		Networks:   commy.Networks,
 		Volumes:    commy.Volumes,
-		Secret:     secret.StaticVars(commy.Secrets),
+		Secret:     secret.StaticVars(secrets),
+		// the credentials of the registry file take precedence over the ones of docker login
 		Registry: registry.Combine(
 			registry.File(commy.Config),
+			dockerConfig(commy.DockerConfig),
 		),
 	}
 
@@ -191,14 +383,21 @@ func exec(cliContext *cli.Context) error {
 	// disabled in favor of mounting the source code
 	// from the current working directory.
 	if !commy.Clone {
-		pwd, _ := os.Getwd()
-		comp.Mount = pwd
+		workspace := commy.Workspace
+		if workspace == "" {
+			workspace, _ = os.Getwd()
+		}
+		comp.Mount = workspace
 		//Add the new labels that helps looking up the step containers
 		//by names
 		if comp.Labels == nil {
 			comp.Labels = make(map[string]string)
 		}
-		comp.Labels[monitor.LabelPipelineFile] = path.Join(pwd, commy.Source)
+		pipelineFile := commy.Source
+		if !filepath.IsAbs(pipelineFile) {
+			pipelineFile = filepath.Join(workspace, pipelineFile)
+		}
+		comp.Labels[monitor.LabelPipelineFile] = pipelineFile
 	}
 
 	args := runtime.CompilerArgs{
@@ -209,12 +408,20 @@ func exec(cliContext *cli.Context) error {
 		Repo:     commy.Repo,
 		Stage:    commy.Stage,
 		System:   commy.System,
-		Secret:   secret.StaticVars(commy.Secrets),
+		Secret:   secret.StaticVars(secrets),
 	}
 	spec := comp.Compile(nocontext, args).(*engine.Spec)
 
-	//Handle to parsed Pipeline
-	p := res.(*resource.Pipeline)
+	// the compiler never runs the steps whose when conditions are not met,
+	// the clone step is never run as the source is mounted
+	skipped := make(map[string]bool)
+	var skippedNames []string
+	for _, step := range spec.Steps {
+		if step.RunPolicy == runtime.RunNever && step.Name != "clone" {
+			skipped[step.Name] = true
+			skippedNames = append(skippedNames, step.Name)
+		}
+	}
 
 	//As the Compiler does not add labels for Steps adding few here
 	for i, step := range spec.Steps {
@@ -223,6 +430,12 @@ func exec(cliContext *cli.Context) error {
 		extraLabels[monitor.LabelStageName] = strings.TrimSpace(p.Name)
 		extraLabels[monitor.LabelStepName] = strings.TrimSpace(step.Name)
 		extraLabels[monitor.LabelStepNumber] = strconv.Itoa(i)
+		//The network is unique for each execution, use it as key to identify the run
+		//unless the run is already known
+		extraLabels[monitor.LabelRunKey] = spec.Network.ID
+		if commy.RunKey != "" {
+			extraLabels[monitor.LabelRunKey] = commy.RunKey
+		}
 
 		//Know the includes while running the pipeline from the extension
 		//TODO improve
@@ -234,6 +447,25 @@ func exec(cliContext *cli.Context) error {
 		if len(commy.Exclude) > 0 {
 			extraLabels[monitor.LabelExcludes] = strings.Join(commy.Exclude, ",")
 		}
+		//Know the secrets to mask while saving the step logs, only the names
+		//of the environment variables are labelled and never the values
+		var maskedEnvs []string
+		for _, sec := range step.Secrets {
+			if sec.Mask {
+				maskedEnvs = append(maskedEnvs, sec.Env)
+			}
+		}
+		if len(maskedEnvs) > 0 {
+			extraLabels[monitor.LabelMaskedEnvs] = strings.Join(maskedEnvs, ",")
+		}
+		//Know the steps skipped by their when conditions
+		if len(skippedNames) > 0 {
+			extraLabels[monitor.LabelSkipped] = strings.Join(skippedNames, ",")
+		}
+		//Know the step the run resumed at, the steps before it keep their statuses
+		if commy.ResumeAt != "" {
+			extraLabels[monitor.LabelResumeAt] = commy.ResumeAt
+		}
 		//Label the services from steps
 		for _, svc := range p.Services {
 			if b := step.Name == svc.Name; b {
@@ -278,56 +510,82 @@ func exec(cliContext *cli.Context) error {
 			}
 		}
 	}
-	// resume at a specific step
-	if cliContext.String("resume-at") != "" {
+	// resume at a specific step, the steps before it are never run except
+	// for the clone, services and detached steps that the later steps rely on
+	if commy.ResumeAt != "" {
+		found := false
 		for _, step := range spec.Steps {
-			if step.Name == cliContext.String("resume-at") {
+			if step.Name == commy.ResumeAt {
+				found = true
 				break
 			}
-			if step.Name == "clone" {
-				continue
+		}
+		if !found {
+			return nil, fmt.Errorf("unable to resume at step %s, no such step in stage %s", commy.ResumeAt, p.Name)
+		}
+		for _, step := range spec.Steps {
+			if step.Name == commy.ResumeAt {
+				break
 			}
-			for _, name := range commy.Exclude {
-				if step.Name == name {
-					step.RunPolicy = runtime.RunNever
-					continue
-				}
+			if step.Name == "clone" || step.Detach {
+				continue
 			}
+			step.RunPolicy = runtime.RunNever
 		}
 	}
+	// the compiler leaves the unresolved secrets out of the steps, fail
+	// rather than run the steps without them
+	notRun := make(map[string]bool)
+	for _, step := range spec.Steps {
+		notRun[step.Name] = step.RunPolicy == runtime.RunNever
+	}
+	if unresolved := unresolvedSecrets(p, secrets, notRun); len(unresolved) > 0 {
+		return nil, fmt.Errorf("unresolved secrets in stage %s: %s; %s", p.Name, strings.Join(unresolved, ", "), secretSources(commy.SecretSources))
+	}
 	// create a step object for each pipeline step.
 	for _, step := range spec.Steps {
+		status := drone.StatusPending
 		if step.RunPolicy == runtime.RunNever {
-			continue
+			if !skipped[step.Name] {
+				continue
+			}
+			// show the step as skipped rather than leaving it out
+			log.Infof("Skipping step %s, the when conditions are not met", step.Name)
+			status = drone.StatusSkipped
 		}
 
 		commy.Stage.Steps = append(commy.Stage.Steps, &drone.Step{
 			StageID:   commy.Stage.ID,
 			Number:    len(commy.Stage.Steps) + 1,
 			Name:      step.Name,
-			Status:    drone.StatusPending,
+			Status:    status,
 			ErrIgnore: step.ErrPolicy == runtime.ErrIgnore,
 		})
 	}
 
-	// configures the pipeline timeout.
-	timeout := time.Duration(commy.Repo.Timeout) * time.Minute
-	ctx, cancel := context.WithTimeout(nocontext, timeout)
-	defer cancel()
-
-	// listen for operating system signals and cancel execution when received.
-	ctx = signal.WithContextFunc(ctx, func() {
-		println("received signal, terminating process")
-		cancel()
-	})
+	return spec, nil
+}
 
-	state := &pipeline.State{
-		Build:  commy.Build,
-		Stage:  commy.Stage,
-		Repo:   commy.Repo,
-		System: commy.System,
+// errStageSkipped is returned when the trigger conditions of the stage are not met
+var errStageSkipped = errors.New("stage skipped, the trigger conditions are not met")
+
+// match is the build that the trigger and when conditions are matched against
+func (commy *execCommand) match() manifest.Match {
+	return manifest.Match{
+		Action:   commy.Build.Action,
+		Cron:     commy.Build.Cron,
+		Ref:      commy.Build.Ref,
+		Repo:     commy.Repo.Slug,
+		Instance: commy.System.Host,
+		Target:   commy.Build.Deploy,
+		Event:    commy.Build.Event,
+		Branch:   commy.Build.Target,
 	}
+}
 
+// run compiles and executes the pipeline stage, returning the final state of the stage.
+// The state is nil when the stage could not be compiled.
+func (commy *execCommand) run(ctx context.Context, log *logrus.Logger) (*pipeline.State, error) {
 	// enable debug logging
 	if commy.Debug {
 		log.SetLevel(logrus.DebugLevel)
@@ -341,31 +599,96 @@ func exec(cliContext *cli.Context) error {
 		),
 	)
 
+	spec, err := commy.compile(log)
+	if errors.Is(err, errStageSkipped) {
+		log.Infof("Skipping stage %s, the trigger conditions are not met", commy.Stage.Name)
+		commy.Stage.Status = drone.StatusSkipped
+		return &pipeline.State{
+			Build:  commy.Build,
+			Stage:  commy.Stage,
+			Repo:   commy.Repo,
+			System: commy.System,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// configures the pipeline timeout.
+	timeout := time.Duration(commy.Repo.Timeout) * time.Minute
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	state := &pipeline.State{
+		Build:  commy.Build,
+		Stage:  commy.Stage,
+		Repo:   commy.Repo,
+		System: commy.System,
+	}
+
 	engine, err := engine.NewEnv(engine.Opts{})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	var reporter pipeline.Reporter = pipeline.NopReporter()
+	var streamer pipeline.Streamer = console.New(commy.Pretty)
+	if commy.reporter != nil {
+		reporter = commy.reporter
+	}
+	if commy.streamer != nil {
+		streamer = commy.streamer
+	}
+	if commy.output != nil {
+		reporter, streamer = commy.output, commy.output
+		if err := commy.output.startStage(state); err != nil {
+			return nil, err
+		}
+	}
+	if m, ok := streamer.(secretsMasker); ok {
+		m.MaskSecrets(maskedSecrets(spec))
 	}
 
 	err = runtime.NewExecer(
-		pipeline.NopReporter(),
-		console.New(commy.Pretty),
+		reporter,
+		streamer,
 		pipeline.NopUploader(),
 		engine,
 		commy.Procs,
 	).Exec(ctx, spec, state)
 
-	if err != nil {
-		dump(state)
-		return err
+	return state, err
+}
+
+// secretsMasker is implemented by the streamers that mask the secrets of the steps in the logs
+// themselves, rather than relying on the masking of the runtime alone
+type secretsMasker interface {
+	// MaskSecrets sets the secret values to mask keyed by the name of the step
+	MaskSecrets(secrets map[string][]string)
+}
+
+// maskedSecrets are the values of the secrets to mask of each step of the spec
+func maskedSecrets(spec *engine.Spec) map[string][]string {
+	secrets := make(map[string][]string)
+	for _, step := range spec.Steps {
+		for _, sec := range step.Secrets {
+			if sec.Mask {
+				secrets[step.Name] = append(secrets[step.Name], string(sec.Data))
+			}
+		}
 	}
-	switch state.Stage.Status {
-	case drone.StatusError, drone.StatusFailing, drone.StatusKilled:
-		os.Exit(1)
+	return secrets
+}
+
+// dump writes the state as a json event with the json output, otherwise as indented json
+func (commy *execCommand) dump(state *pipeline.State) {
+	if commy.output != nil {
+		_ = commy.output.dumpState(state)
+		return
 	}
-	return nil
+	dump(state)
 }
 
-// TODO new JSON logger
 func dump(v interface{}) {
 	enc := json.NewEncoder(os.Stdout)
 	enc.SetIndent("", "  ")
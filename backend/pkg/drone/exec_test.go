@@ -0,0 +1,202 @@
+package drone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/drone-runners/drone-runner-docker/engine"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline/runtime"
+	"github.com/harness/drone-ci-docker-extension/pkg/monitor"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const conditionsPipeline = `
+kind: pipeline
+type: docker
+name: default
+
+trigger:
+  branch:
+  - main
+  - feature/*
+
+steps:
+- name: build
+  image: busybox
+  commands:
+  - echo build
+- name: publish
+  image: busybox
+  commands:
+  - echo publish
+  when:
+    event:
+    - tag
+- name: notify
+  image: busybox
+  commands:
+  - echo notify
+  when:
+    branch:
+    - main
+`
+
+func TestCompileConditions(t *testing.T) {
+	compileTests := map[string]struct {
+		branch      string
+		event       string
+		wantSkipped bool
+		wantSteps   map[string]string
+	}{
+		"mainPush": {
+			branch: "main",
+			event:  "push",
+			wantSteps: map[string]string{
+				"build":   drone.StatusPending,
+				"publish": drone.StatusSkipped,
+				"notify":  drone.StatusPending,
+			},
+		},
+		"featurePush": {
+			branch: "feature/conditions",
+			event:  "push",
+			wantSteps: map[string]string{
+				"build":   drone.StatusPending,
+				"publish": drone.StatusSkipped,
+				"notify":  drone.StatusSkipped,
+			},
+		},
+		"notTriggered": {
+			branch:      "fix/conditions",
+			event:       "push",
+			wantSkipped: true,
+		},
+	}
+
+	for name, tc := range compileTests {
+		t.Run(name, func(t *testing.T) {
+			commy := Options{
+				PipelineFile: "/tmp/examples/conditions/.drone.yml",
+				Config:       []byte(conditionsPipeline),
+				Stage:        "default",
+			}.toExecCommand()
+			commy.Build.Event = tc.event
+			commy.Build.Target = tc.branch
+
+			spec, err := commy.compile(logrus.New())
+			if tc.wantSkipped {
+				assert.ErrorIs(t, err, errStageSkipped)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			gotSteps := make(map[string]string)
+			for _, step := range commy.Stage.Steps {
+				gotSteps[step.Name] = step.Status
+			}
+			assert.Equal(t, tc.wantSteps, gotSteps)
+
+			var wantLabel []string
+			for _, name := range []string{"build", "publish", "notify"} {
+				if tc.wantSteps[name] == drone.StatusSkipped {
+					wantLabel = append(wantLabel, name)
+				}
+			}
+			for _, step := range spec.Steps {
+				if step.Name == "clone" {
+					assert.Equal(t, runtime.RunNever, step.RunPolicy)
+					continue
+				}
+				assert.Equal(t, strings.Join(wantLabel, ","), step.Labels[monitor.LabelSkipped], step.Name)
+			}
+		})
+	}
+}
+
+const resumePipeline = `
+kind: pipeline
+type: docker
+name: default
+
+services:
+- name: redis
+  image: redis
+
+steps:
+- name: build
+  image: busybox
+- name: test
+  image: busybox
+- name: publish
+  image: busybox
+`
+
+func TestCompileResumeAt(t *testing.T) {
+	resumeTests := map[string]struct {
+		resumeAt  string
+		wantSteps []string
+		wantError string
+	}{
+		"resumeAtTest": {
+			resumeAt:  "test",
+			wantSteps: []string{"redis", "test", "publish"},
+		},
+		"resumeAtFirst": {
+			resumeAt:  "build",
+			wantSteps: []string{"redis", "build", "test", "publish"},
+		},
+		"unknownStep": {
+			resumeAt:  "deploy",
+			wantError: "unable to resume at step deploy, no such step in stage default",
+		},
+	}
+
+	for name, tc := range resumeTests {
+		t.Run(name, func(t *testing.T) {
+			commy := Options{
+				PipelineFile: "/tmp/examples/resume/.drone.yml",
+				Config:       []byte(resumePipeline),
+				Stage:        "default",
+				ResumeAt:     tc.resumeAt,
+			}.toExecCommand()
+
+			spec, err := commy.compile(logrus.New())
+			if tc.wantError != "" {
+				assert.EqualError(t, err, tc.wantError)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var gotSteps []string
+			for _, step := range commy.Stage.Steps {
+				gotSteps = append(gotSteps, step.Name)
+			}
+			assert.Equal(t, tc.wantSteps, gotSteps)
+			for _, step := range spec.Steps {
+				assert.Equal(t, tc.resumeAt, step.Labels[monitor.LabelResumeAt], step.Name)
+			}
+		})
+	}
+}
+
+func TestMaskedSecrets(t *testing.T) {
+	spec := &engine.Spec{
+		Steps: []*engine.Step{
+			{
+				Name: "build",
+				Secrets: []*engine.Secret{
+					{Env: "TOKEN", Data: []byte("s3cr3t"), Mask: true},
+					{Env: "USERNAME", Data: []byte("octocat")},
+				},
+			},
+			{Name: "test"},
+		},
+	}
+	assert.Equal(t, map[string][]string{"build": {"s3cr3t"}}, maskedSecrets(spec))
+}
@@ -5,10 +5,13 @@
 package drone
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/drone-runners/drone-runner-docker/engine/compiler"
 	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
 	"github.com/joho/godotenv"
 	"github.com/urfave/cli/v2"
 )
@@ -33,7 +36,6 @@ type execCommand struct {
 	Volumes    map[string]string
 	Environ    map[string]string
 	Labels     map[string]string
-	Secrets    map[string]string
 	Resources  compiler.Resources
 	Tmate      compiler.Tmate
 	Clone      bool
@@ -45,26 +47,75 @@ type execCommand struct {
 	Dump       bool
 	PublicKey  string
 	PrivateKey string
+
+	// RawSource is the content of the pipeline file, the Source is read when not set
+	RawSource []byte
+	// Workspace is the directory mounted as the source of the build, defaults to the current working directory
+	Workspace string
+	// All executes all the pipelines of the pipeline file in the order of their depends_on
+	All bool
+	// SecretSources are the sources of the values of from_secret, the first source with a secret wins
+	SecretSources []secretSource
+	// DockerConfig is the docker CLI config file with the credentials of docker login, defaults to ~/.docker/config.json
+	DockerConfig string
+	// ResumeAt is the name of the step to resume the pipeline at
+	ResumeAt string
+	// RunKey identifies the run that the step containers belong to
+	RunKey string
+	// Output is the format of the run output, text or json
+	Output string
+	// output writes the run as json events when the Output is json
+	output *jsonOutput
+	// reporter and streamer report the run when set, unless the output is json
+	reporter pipeline.Reporter
+	streamer pipeline.Streamer
+	// Envs are the DRONE_ environment variables used for the substitutions in the pipeline file
+	Envs map[string]string
 }
 
 func toExecCommand(input *cli.Context) (returnVal *execCommand) {
 	pipelineFile := input.Args().First()
 	if pipelineFile == "" {
-		pipelineFile = ".drone.yml"
+		pipelineFile = defaultPipelineFile()
+	}
+
+	// the commit details default to the local git checkout
+	branch, sha, ref := input.String("branch"), input.String("sha"), input.String("ref")
+	if branch == "" || sha == "" || ref == "" {
+		git := readGitInfo(filepath.Dir(pipelineFile))
+		if branch == "" {
+			branch = git.Branch
+		}
+		if sha == "" {
+			sha = git.Sha
+		}
+		if ref == "" {
+			ref = git.Ref
+		}
+	}
+
+	slug := input.String("repo")
+	name := input.String("name")
+	if name == "" && slug != "" {
+		name = slug[strings.LastIndex(slug, "/")+1:]
 	}
+
 	returnVal = &execCommand{
 		Flags: &Flags{
 			Build: &drone.Build{
 				Event:  input.String("event"),
-				Ref:    input.String("ref"),
+				Ref:    ref,
+				After:  sha,
 				Deploy: input.String("deploy-to"),
-				Target: input.String("branch"),
+				Source: branch,
+				Target: branch,
 			},
 			Repo: &drone.Repo{
 				Trusted: input.Bool("trusted"),
-				Timeout: int64(input.Duration("timeout").Seconds()),
-				Branch:  input.String("branch"),
-				Name:    input.String("name"),
+				Timeout: int64(input.Duration("timeout").Minutes()),
+				Branch:  branch,
+				Slug:    slug,
+				Name:    name,
 			},
 			Stage: &drone.Stage{
 				Name: input.String("pipeline"),
@@ -85,14 +136,33 @@ func toExecCommand(input *cli.Context) (returnVal *execCommand) {
 		Networks:   input.StringSlice("network"),
 		Environ:    readParams(input.String("env-file")),
 		Volumes:    withVolumeSlice(input.StringSlice("volume")),
-		Secrets:    readParams(input.String("secret-file")),
 		Config:     input.String("registry"),
 		Privileged: input.StringSlice("privileged"),
+		Pretty:     input.Bool("pretty"),
+		Debug:      input.Bool("debug"),
+		Trace:      input.Bool("trace"),
+		ResumeAt:   input.String("resume-at"),
+		All:        input.Bool("all"),
+		Output:     input.String("output"),
 	}
+	returnVal.DockerConfig = input.String("docker-config")
+	returnVal.SecretSources = toSecretSources(input)
+	returnVal.Envs = getEnv(returnVal.Flags)
 
 	return returnVal
 }
 
+// defaultPipelineFile is the first pipeline file of the current directory out of .drone.yml,
+// .drone.jsonnet and .drone.star, .drone.yml when there is none
+func defaultPipelineFile() string {
+	for _, f := range []string{".drone.yml", ".drone.jsonnet", ".drone.star"} {
+		if _, err := os.Stat(f); err == nil {
+			return f
+		}
+	}
+	return ".drone.yml"
+}
+
 // WithVolumeSlice is a transform function that adds a set of global volumes to the container that are defined in --volume=host:container format.
 func withVolumeSlice(volumes []string) (to map[string]string) {
 	to = map[string]string{}
@@ -108,6 +178,50 @@ func withVolumeSlice(volumes []string) (to map[string]string) {
 	return to
 }
 
+// secretFlags are the flags of the sources of the values of the from_secret references, shared
+// by the exec, compile and lint commands
+var secretFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "secret-file",
+		Aliases: []string{"secrets"},
+		Usage:   "secret file, define values that can be used with from_secret",
+	},
+	&cli.StringFlag{
+		Name:    "secret-env-prefix",
+		Usage:   "prefix of the environment variables that define values that can be used with from_secret e.g. DRONE_SECRET_ for DRONE_SECRET_TOKEN to be used as token",
+		EnvVars: []string{"DRONE_SECRET_ENV_PREFIX"},
+	},
+	&cli.StringFlag{
+		Name:  "secret-age-file",
+		Usage: "secret file encrypted with age, define values that can be used with from_secret",
+	},
+	&cli.StringFlag{
+		Name:    "secret-age-identity",
+		Usage:   "age identity file to decrypt the secret-age-file",
+		EnvVars: []string{"DRONE_SECRET_AGE_IDENTITY"},
+	},
+	&cli.StringFlag{
+		Name:  "secret-command",
+		Usage: "command that writes the values that can be used with from_secret as a JSON object, run with the shell",
+	},
+}
+
+// toSecretOptions are the secret sources of the flags
+func toSecretOptions(input *cli.Context) SecretOptions {
+	return SecretOptions{
+		File:        input.String("secret-file"),
+		EnvPrefix:   input.String("secret-env-prefix"),
+		AgeFile:     input.String("secret-age-file"),
+		AgeIdentity: input.String("secret-age-identity"),
+		Command:     input.String("secret-command"),
+	}
+}
+
+// toSecretSources stacks the secret sources of the flags
+func toSecretSources(input *cli.Context) []secretSource {
+	return toSecretOptions(input).sources()
+}
+
 // helper function reads secrets from a key-value file.
 func readParams(path string) map[string]string {
 	data, _ := godotenv.Read(path)
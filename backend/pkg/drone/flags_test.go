@@ -0,0 +1,82 @@
+package drone
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestToExecCommand(t *testing.T) {
+	// the environment of the flags is not to leak into the test
+	for _, env := range []string{"DRONE_EVENT", "DRONE_COMMIT_REF", "DRONE_BRANCH", "DRONE_COMMIT_SHA", "DRONE_REPO", "DRONE_DEPLOY_TO", "DRONE_SYSTEM_HOST"} {
+		t.Setenv(env, "")
+	}
+	pipelineFile := filepath.Join(t.TempDir(), ".drone.yml")
+
+	var commy *execCommand
+	app := &cli.App{
+		Flags: execFlags,
+		Action: func(c *cli.Context) error {
+			commy = toExecCommand(c)
+			return nil
+		},
+	}
+	err := app.Run([]string{"exec",
+		"--event", "promote",
+		"--ref", "refs/tags/v1.0.0",
+		"--branch", "main",
+		"--sha", "4a5b6c7d",
+		"--repo", "octocat/hello-world",
+		"--deploy-to", "production",
+		"--instance", "drone.example.com",
+		"--netrc-machine", "github.com",
+		"--netrc-username", "octocat",
+		"--netrc-password", "s3cr3t",
+		"--timeout", "90m",
+		pipelineFile,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, pipelineFile, commy.Source)
+	assert.Equal(t, &drone.Build{
+		Event:  "promote",
+		Ref:    "refs/tags/v1.0.0",
+		After:  "4a5b6c7d",
+		Deploy: "production",
+		Source: "main",
+		Target: "main",
+	}, commy.Build)
+	assert.Equal(t, &drone.Repo{
+		Timeout: 90,
+		Branch:  "main",
+		Slug:    "octocat/hello-world",
+		// the name defaults to the name in the slug
+		Name: "hello-world",
+	}, commy.Repo)
+	assert.Equal(t, &drone.Netrc{
+		Machine:  "github.com",
+		Login:    "octocat",
+		Password: "s3cr3t",
+	}, commy.Netrc)
+	assert.Equal(t, "drone.example.com", commy.System.Host)
+
+	for k, v := range map[string]string{
+		"DRONE_EVENT":           "promote",
+		"DRONE_BRANCH":          "main",
+		"DRONE_COMMIT_BRANCH":   "main",
+		"DRONE_TARGET_BRANCH":   "main",
+		"DRONE_COMMIT_REF":      "refs/tags/v1.0.0",
+		"DRONE_COMMIT_SHA":      "4a5b6c7d",
+		"DRONE_REPO":            "octocat/hello-world",
+		"DRONE_DEPLOY_TO":       "production",
+		"DRONE_SYSTEM_HOST":     "drone.example.com",
+		"DRONE_SYSTEM_HOSTNAME": "drone.example.com",
+	} {
+		assert.Equalf(t, v, commy.Envs[k], "Expecting %s to be %s", k, v)
+	}
+}
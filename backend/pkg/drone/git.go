@@ -0,0 +1,39 @@
+package drone
+
+import (
+	osexec "os/exec"
+	"strings"
+)
+
+// gitInfo holds the commit details of the local git checkout
+type gitInfo struct {
+	Branch string
+	Sha    string
+	Ref    string
+}
+
+// readGitInfo reads the commit details of the git checkout in the directory dir. The details
+// are empty when the directory is not a git checkout or when git is not installed.
+func readGitInfo(dir string) gitInfo {
+	var info gitInfo
+	git := func(args ...string) string {
+		out, err := osexec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	info.Sha = git("rev-parse", "HEAD")
+	if info.Sha == "" {
+		return info
+	}
+	if branch := git("symbolic-ref", "--quiet", "--short", "HEAD"); branch != "" {
+		info.Branch = branch
+		info.Ref = "refs/heads/" + branch
+	} else if tag := git("describe", "--tags", "--exact-match"); tag != "" {
+		// a detached HEAD at a tag, as when checking out a release
+		info.Ref = "refs/tags/" + tag
+	}
+	return info
+}
@@ -0,0 +1,52 @@
+package drone
+
+import (
+	"os"
+	osexec "os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gitCommand runs the git commands in the dir, the test is skipped when git is not installed
+func gitCommand(t *testing.T, dir string) func(args ...string) string {
+	if _, err := osexec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	return func(args ...string) string {
+		cmd := osexec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=drone", "GIT_AUTHOR_EMAIL=drone@example.com",
+			"GIT_COMMITTER_NAME=drone", "GIT_COMMITTER_EMAIL=drone@example.com")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %v failed, %v", args, err)
+		}
+		return string(out)
+	}
+}
+
+func TestReadGitInfo(t *testing.T) {
+	dir := t.TempDir()
+	git := gitCommand(t, dir)
+
+	assert.Equal(t, gitInfo{}, readGitInfo(dir), "Expecting no details outside a git checkout")
+
+	git("init", "--quiet", "--initial-branch", "main")
+	git("commit", "--quiet", "--allow-empty", "--message", "initial")
+	sha := git("rev-parse", "HEAD")
+	sha = sha[:len(sha)-1]
+
+	assert.Equal(t, gitInfo{
+		Branch: "main",
+		Sha:    sha,
+		Ref:    "refs/heads/main",
+	}, readGitInfo(dir))
+
+	git("tag", "v1.0.0")
+	git("checkout", "--quiet", "--detach", "v1.0.0")
+	assert.Equal(t, gitInfo{
+		Sha: sha,
+		Ref: "refs/tags/v1.0.0",
+	}, readGitInfo(dir))
+}
@@ -0,0 +1,367 @@
+package drone
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	buildkiteyaml "github.com/buildkite/yaml"
+	"github.com/drone-runners/drone-runner-docker/engine/linter"
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/manifest"
+	"github.com/harness/drone-ci-docker-extension/pkg/converter"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Diagnostic is a problem found in a pipeline file, the pipeline, step, line and
+// column are set when the problem could be located
+type Diagnostic struct {
+	File     string `json:"file"`
+	Pipeline string `json:"pipeline,omitempty"`
+	Step     string `json:"step,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Message  string `json:"message"`
+}
+
+// LintOptions are the options to lint a pipeline file
+type LintOptions struct {
+	// Trusted allows the privileged steps and the host volumes
+	Trusted bool
+	// Secrets are the sources of the from_secret references, the references are not checked
+	// when there are none
+	Secrets SecretOptions
+}
+
+// LintCommand exports the lint command.
+var LintCommand = &cli.Command{
+	Name:      "lint",
+	Usage:     "lint all the pipelines of a pipeline file",
+	ArgsUsage: "[path/to/.drone.yml|.drone.jsonnet|.drone.star]",
+	Action: func(ctx *cli.Context) error {
+		if err := lint(ctx); err != nil {
+			log.Fatalln(err)
+		}
+		return nil
+	},
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:  "trusted",
+			Usage: "build is trusted",
+		},
+	}, secretFlags...),
+}
+
+func lint(cliContext *cli.Context) error {
+	pipelineFile := cliContext.Args().First()
+	if pipelineFile == "" {
+		pipelineFile = defaultPipelineFile()
+	}
+	data, err := os.ReadFile(pipelineFile)
+	if err != nil {
+		return err
+	}
+
+	diagnostics := Lint(pipelineFile, data, LintOptions{
+		Trusted: cliContext.Bool("trusted"),
+		Secrets: toSecretOptions(cliContext),
+	})
+	if err := writeSpec(cliContext.App.Writer, "json", diagnostics); err != nil {
+		return err
+	}
+	if len(diagnostics) > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// yamlErrLine extracts the line from the YAML errors e.g. `yaml: line 3: mapping values are not allowed`
+var yamlErrLine = regexp.MustCompile(`line (\d+)`)
+
+// Lint reports all the problems of the docker pipelines in the pipeline file data, the file is
+// used to identify the pipeline file in the diagnostics. No diagnostics are returned when the
+// pipelines have no problems.
+func Lint(file string, data []byte, opts LintOptions) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+	parseError := func(err error) []Diagnostic {
+		d := Diagnostic{
+			File:    file,
+			Message: err.Error(),
+		}
+		if match := yamlErrLine.FindStringSubmatch(err.Error()); match != nil {
+			d.Line, _ = strconv.Atoi(match[1])
+		}
+		return append(diagnostics, d)
+	}
+
+	// the Jsonnet and Starlark configs are linted as rendered, the lines are the ones of the rendered YAML
+	data, err := converter.Convert(file, data, nil)
+	if err != nil {
+		return parseError(err)
+	}
+
+	// the documents are parsed one by one rather than with manifest.Parse, that stops at the first
+	// invalid pipeline, the nodes of the documents are to locate the problems
+	raws, err := manifest.ParseRaw(bytes.NewReader(data))
+	if err != nil {
+		return parseError(err)
+	}
+	docs, err := yamlDocuments(data)
+	if err != nil {
+		return parseError(err)
+	}
+	resources := make([]*manifest.RawResource, 0, len(raws))
+	for _, raw := range raws {
+		if raw != nil && raw.Kind != "" {
+			resources = append(resources, raw)
+		}
+	}
+	// the problems could not be located when the documents do not line up
+	if len(docs) != len(resources) {
+		docs = make([]*yaml.Node, len(resources))
+	}
+
+	l := &pipelineLinter{
+		file:      file,
+		trusted:   opts.Trusted,
+		pipelines: make(map[string]bool),
+		seen:      make(map[string]bool),
+	}
+	// the secrets are resolved the same as exec does, the secrets that could not be loaded are
+	// reported once rather than as unresolved references
+	if sources := opts.Secrets.sources(); len(sources) > 0 {
+		secrets, err := loadSecrets(nocontext, sources)
+		if err != nil {
+			l.report("", "", nil, "%s", err)
+		} else {
+			l.secrets = secrets
+		}
+	}
+	for _, raw := range resources {
+		if isDockerPipeline(raw) {
+			l.pipelines[raw.Name] = true
+		}
+	}
+
+	m := new(manifest.Manifest)
+	for i, raw := range resources {
+		if !isDockerPipeline(raw) {
+			continue
+		}
+		p := new(resource.Pipeline)
+		if err := buildkiteyaml.Unmarshal(raw.Data, p); err != nil {
+			l.report(raw.Name, "", docs[i], "%s", err)
+			continue
+		}
+		l.lintPipeline(p, docs[i])
+		m.Resources = append(m.Resources, p)
+	}
+
+	// the dependency cycles are reported once all the pipelines are known to exist
+	if len(l.diagnostics) == 0 {
+		if _, err := stageGraph(m); err != nil {
+			l.diagnostics = append(l.diagnostics, Diagnostic{
+				File:    file,
+				Message: err.Error(),
+			})
+		}
+	}
+
+	return append(diagnostics, l.diagnostics...)
+}
+
+// isDockerPipeline reports whether the resource is a pipeline of the docker runner
+func isDockerPipeline(raw *manifest.RawResource) bool {
+	return raw.Kind == resource.Kind && (raw.Type == resource.Type || raw.Type == "")
+}
+
+// pipelineLinter collects the diagnostics of the pipelines of a pipeline file, the secrets are keyed
+// by their lower case names and are nil when the from_secret references are not checked
+type pipelineLinter struct {
+	file        string
+	trusted     bool
+	secrets     map[string]string
+	pipelines   map[string]bool
+	seen        map[string]bool
+	diagnostics []Diagnostic
+}
+
+func (l *pipelineLinter) report(pipeline, step string, node *yaml.Node, format string, args ...interface{}) {
+	d := Diagnostic{
+		File:     l.file,
+		Pipeline: pipeline,
+		Step:     step,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if node != nil {
+		d.Line = node.Line
+		d.Column = node.Column
+	}
+	l.diagnostics = append(l.diagnostics, d)
+}
+
+// lintPipeline checks the pipeline p defined by the YAML document doc
+func (l *pipelineLinter) lintPipeline(p *resource.Pipeline, doc *yaml.Node) {
+	reported := len(l.diagnostics)
+	nameNode := mappingValue(doc, "name")
+	if l.seen[p.Name] {
+		l.report(p.Name, "", position(nameNode, doc), "duplicate pipeline name %s", p.Name)
+	}
+	l.seen[p.Name] = true
+
+	for i, dep := range p.Deps {
+		if !l.pipelines[dep] {
+			l.report(p.Name, "", sequenceItem(mappingValue(doc, "depends_on"), i, doc), "pipeline %s depends on unknown pipeline %s", p.Name, dep)
+		}
+	}
+
+	names := make(map[string]bool)
+	if !p.Clone.Disable {
+		names["clone"] = true
+	}
+	type stepNodes struct {
+		steps []*resource.Step
+		nodes *yaml.Node
+	}
+	for _, group := range []stepNodes{
+		{p.Services, mappingValue(doc, "services")},
+		{p.Steps, mappingValue(doc, "steps")},
+	} {
+		for i, step := range group.steps {
+			if step == nil {
+				continue
+			}
+			stepNode := sequenceItem(group.nodes, i, doc)
+			l.lintStep(p, step, stepNode, names)
+			names[step.Name] = true
+		}
+	}
+
+	// the rules of the drone linter that are not covered above e.g. the volumes,
+	// reported only when the pipeline has no other problems as it stops at the first one
+	if len(l.diagnostics) == reported {
+		if err := linter.New().Lint(p, &drone.Repo{Trusted: l.trusted}); err != nil {
+			l.report(p.Name, "", position(nameNode, doc), "%s", err)
+		}
+	}
+}
+
+// lintStep checks the step of the pipeline p, names are the names of the steps defined before it
+func (l *pipelineLinter) lintStep(p *resource.Pipeline, step *resource.Step, node *yaml.Node, names map[string]bool) {
+	if names[step.Name] {
+		l.report(p.Name, step.Name, position(mappingValue(node, "name"), node), "duplicate step name %s", step.Name)
+	}
+	if step.Image == "" {
+		l.report(p.Name, step.Name, position(mappingValue(node, "image"), node), "step %s has no image", step.Name)
+	}
+	if step.Privileged && !l.trusted {
+		l.report(p.Name, step.Name, position(mappingValue(node, "privileged"), node), "step %s is privileged, privileged steps require the build to be trusted", step.Name)
+	}
+	for i, dep := range step.DependsOn {
+		if !names[dep] {
+			l.report(p.Name, step.Name, sequenceItem(mappingValue(node, "depends_on"), i, node), "step %s depends on unknown step %s, the steps could only depend on the steps defined before them", step.Name, dep)
+		}
+	}
+
+	for _, section := range []string{"environment", "settings"} {
+		values := mappingValue(node, section)
+		for _, kv := range mappingPairs(values) {
+			ref := mappingValue(kv[1], "from_secret")
+			if ref == nil || ref.Kind != yaml.ScalarNode || l.secrets == nil {
+				continue
+			}
+			if _, ok := l.secrets[strings.ToLower(ref.Value)]; !ok {
+				l.report(p.Name, step.Name, ref, "unresolved secret %s of %s %s", ref.Value, section, kv[0].Value)
+			}
+		}
+	}
+}
+
+// yamlDocuments decodes the documents of the YAML data to their nodes
+func yamlDocuments(data []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		doc := new(yaml.Node)
+		err := dec.Decode(doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// only the documents with a kind are resources of the manifest
+		if len(doc.Content) == 0 || mappingValue(doc.Content[0], "kind") == nil {
+			continue
+		}
+		docs = append(docs, doc.Content[0])
+	}
+}
+
+// mappingPairs gets the key and value nodes of the mapping node, the aliases and the merge keys are resolved
+func mappingPairs(node *yaml.Node) [][2]*yaml.Node {
+	node = resolve(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	var pairs, merged [][2]*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "<<" && key.Tag == "!!merge" {
+			value = resolve(value)
+			if value.Kind == yaml.SequenceNode {
+				for _, item := range value.Content {
+					merged = append(merged, mappingPairs(item)...)
+				}
+			} else {
+				merged = append(merged, mappingPairs(value)...)
+			}
+			continue
+		}
+		pairs = append(pairs, [2]*yaml.Node{key, value})
+	}
+	// the keys of the mapping override the merged keys
+	return append(pairs, merged...)
+}
+
+// mappingValue gets the value node of the key in the mapping node, nil when there is no such key
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for _, kv := range mappingPairs(node) {
+		if kv[0].Value == key {
+			return resolve(kv[1])
+		}
+	}
+	return nil
+}
+
+// sequenceItem gets the i-th item of the sequence node, the parent is used when there is no such item
+func sequenceItem(node *yaml.Node, i int, parent *yaml.Node) *yaml.Node {
+	node = resolve(node)
+	if node == nil || node.Kind != yaml.SequenceNode || i >= len(node.Content) {
+		return parent
+	}
+	return resolve(node.Content[i])
+}
+
+// position is the node to locate a diagnostic, the parent is used when the node does not exist
+func position(node, parent *yaml.Node) *yaml.Node {
+	if node == nil {
+		return parent
+	}
+	return node
+}
+
+func resolve(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
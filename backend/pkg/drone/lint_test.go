@@ -0,0 +1,164 @@
+package drone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const lintPipeline = `kind: pipeline
+type: docker
+name: build
+
+steps:
+- name: build
+  image: golang
+  environment:
+    TOKEN:
+      from_secret: token
+- name: build
+  image: golang
+- name: publish
+  privileged: true
+  depends_on:
+  - deploy
+  settings:
+    password:
+      from_secret: password
+
+---
+kind: pipeline
+type: docker
+name: notify
+
+depends_on:
+- build
+- test
+
+steps:
+- name: notify
+  image: plugins/slack
+`
+
+const lintSecretsPipeline = `kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: build
+  image: golang
+  environment:
+    TOKEN:
+      from_secret: TOKEN
+- name: publish
+  image: plugins/docker
+  settings:
+    password:
+      from_secret: password
+`
+
+func TestLint(t *testing.T) {
+	lintTests := map[string]struct {
+		config string
+		env    map[string]string
+		opts   LintOptions
+		want   []Diagnostic
+	}{
+		"allProblems": {
+			config: lintPipeline,
+			opts: LintOptions{
+				Secrets: SecretOptions{File: "token=s3cr3t"},
+			},
+			want: []Diagnostic{
+				{File: ".drone.yml", Pipeline: "build", Step: "build", Line: 11, Column: 9, Message: "duplicate step name build"},
+				{File: ".drone.yml", Pipeline: "build", Step: "publish", Line: 13, Column: 3, Message: "step publish has no image"},
+				{File: ".drone.yml", Pipeline: "build", Step: "publish", Line: 14, Column: 15, Message: "step publish is privileged, privileged steps require the build to be trusted"},
+				{File: ".drone.yml", Pipeline: "build", Step: "publish", Line: 16, Column: 5, Message: "step publish depends on unknown step deploy, the steps could only depend on the steps defined before them"},
+				{File: ".drone.yml", Pipeline: "build", Step: "publish", Line: 19, Column: 20, Message: "unresolved secret password of settings password"},
+				{File: ".drone.yml", Pipeline: "notify", Line: 28, Column: 3, Message: "pipeline notify depends on unknown pipeline test"},
+			},
+		},
+		"valid": {
+			config: `
+kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: build
+  image: golang
+  privileged: true
+`,
+			opts: LintOptions{Trusted: true},
+			want: []Diagnostic{},
+		},
+		"droneLinter": {
+			config: `
+kind: pipeline
+type: docker
+name: default
+
+steps:
+- name: build
+  image: golang
+
+volumes:
+- name: cache
+  host:
+    path: /tmp/cache
+`,
+			want: []Diagnostic{
+				{File: ".drone.yml", Pipeline: "default", Line: 4, Column: 7, Message: "linter: untrusted repositories cannot mount host volumes"},
+			},
+		},
+		"envSecrets": {
+			config: lintSecretsPipeline,
+			env:    map[string]string{"LINT_SECRET_TOKEN": "s3cr3t"},
+			opts: LintOptions{
+				Secrets: SecretOptions{EnvPrefix: "LINT_SECRET_"},
+			},
+			want: []Diagnostic{
+				{File: ".drone.yml", Pipeline: "default", Step: "publish", Line: 15, Column: 20, Message: "unresolved secret password of settings password"},
+			},
+		},
+		// the references are not checked without the sources of the secrets
+		"noSecrets": {
+			config: lintSecretsPipeline,
+			want:   []Diagnostic{},
+		},
+		"secretsNotLoaded": {
+			config: lintSecretsPipeline,
+			opts: LintOptions{
+				Secrets: SecretOptions{AgeFile: "/tmp/examples/secrets.age"},
+			},
+			want: []Diagnostic{
+				{File: ".drone.yml", Message: "unable to decrypt the secret file /tmp/examples/secrets.age, no age identity file"},
+			},
+		},
+		"invalidYAML": {
+			config: "kind: pipeline\nsteps:\n- name: build\n  image: [golang\n",
+			want: []Diagnostic{
+				{File: ".drone.yml", Line: 4, Message: "yaml: line 4: did not find expected ',' or ']'"},
+			},
+		},
+	}
+
+	for name, tc := range lintTests {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			// the secret file is written from its content
+			if tc.opts.Secrets.File != "" {
+				secretFile := filepath.Join(t.TempDir(), "secrets")
+				if err := os.WriteFile(secretFile, []byte(tc.opts.Secrets.File), 0600); err != nil {
+					t.Fatal(err)
+				}
+				tc.opts.Secrets.File = secretFile
+			}
+			assert.Equal(t, tc.want, Lint(".drone.yml", []byte(tc.config), tc.opts))
+		})
+	}
+}
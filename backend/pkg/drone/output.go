@@ -0,0 +1,183 @@
+package drone
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+)
+
+// outputType is the type of the event of the json output
+type outputType string
+
+const (
+	//stageStarted is written when a stage starts to run
+	stageStarted outputType = "stage-started"
+	//stepStarted is written when a step starts to run
+	stepStarted outputType = "step-started"
+	//logLine is written for each line of the step logs
+	logLine outputType = "log"
+	//stepFinished is written when a step exits or is skipped
+	stepFinished outputType = "step-finished"
+	//stageFinished is written with the result of a stage
+	stageFinished outputType = "stage-finished"
+	//stateDumped is written with the final state of a stage
+	stateDumped outputType = "state"
+)
+
+// outputEvent is a line of the json output
+type outputEvent struct {
+	Type      outputType      `json:"type"`
+	Stage     string          `json:"stage,omitempty"`
+	Step      string          `json:"step,omitempty"`
+	Status    string          `json:"status,omitempty"`
+	ExitCode  *int            `json:"exitCode,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Line      string          `json:"line,omitempty"`
+	State     *pipeline.State `json:"state,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+var (
+	_ pipeline.Reporter = (*jsonOutput)(nil)
+	_ pipeline.Streamer = (*jsonOutput)(nil)
+)
+
+// jsonOutput writes the progress of the run as newline delimited JSON events, it is
+// both the reporter and the streamer of the run. The stages could share it.
+type jsonOutput struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func newJSONOutput(w io.Writer) *jsonOutput {
+	return &jsonOutput{
+		enc: json.NewEncoder(w),
+		now: time.Now,
+	}
+}
+
+func (o *jsonOutput) write(e outputEvent) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e.Timestamp = o.now()
+	return o.enc.Encode(e)
+}
+
+// startStage writes the start of the stage
+func (o *jsonOutput) startStage(state *pipeline.State) error {
+	return o.write(outputEvent{
+		Type:  stageStarted,
+		Stage: state.Stage.Name,
+	})
+}
+
+// dumpState writes the final state of the stage
+func (o *jsonOutput) dumpState(state *pipeline.State) error {
+	return o.write(outputEvent{
+		Type:  stateDumped,
+		Stage: state.Stage.Name,
+		State: state,
+	})
+}
+
+// ReportStage implements pipeline.Reporter
+func (o *jsonOutput) ReportStage(_ context.Context, state *pipeline.State) error {
+	state.Lock()
+	e := outputEvent{
+		Type:   stageFinished,
+		Stage:  state.Stage.Name,
+		Status: state.Stage.Status,
+		Error:  state.Stage.Error,
+	}
+	state.Unlock()
+	return o.write(e)
+}
+
+// ReportStep implements pipeline.Reporter
+func (o *jsonOutput) ReportStep(_ context.Context, state *pipeline.State, name string) error {
+	step := state.Find(name)
+	state.Lock()
+	e := outputEvent{
+		Type:   stepFinished,
+		Stage:  state.Stage.Name,
+		Step:   name,
+		Status: step.Status,
+		Error:  step.Error,
+	}
+	if step.Status == drone.StatusRunning {
+		e.Type = stepStarted
+	} else {
+		exitCode := step.ExitCode
+		e.ExitCode = &exitCode
+	}
+	state.Unlock()
+	return o.write(e)
+}
+
+// Stream implements pipeline.Streamer
+func (o *jsonOutput) Stream(_ context.Context, state *pipeline.State, name string) io.WriteCloser {
+	return &logWriter{
+		out:   o,
+		stage: state.Stage.Name,
+		step:  name,
+	}
+}
+
+// maxLineLength caps the incomplete line held by the logWriter e.g. of the progress bars that never
+// end the line, the longer lines are written in parts
+const maxLineLength = 64 * 1024
+
+// logWriter writes the step logs as an event per line, the incomplete last line is written on Close
+type logWriter struct {
+	out     *jsonOutput
+	stage   string
+	step    string
+	partial []byte
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(data[:i]); err != nil {
+			return 0, err
+		}
+		data = data[i+1:]
+	}
+	for len(data) > maxLineLength {
+		if err := w.writeLine(data[:maxLineLength]); err != nil {
+			return 0, err
+		}
+		data = data[maxLineLength:]
+	}
+	w.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (w *logWriter) Close() error {
+	if len(w.partial) == 0 {
+		return nil
+	}
+	line := w.partial
+	w.partial = nil
+	return w.writeLine(line)
+}
+
+func (w *logWriter) writeLine(line []byte) error {
+	return w.out.write(outputEvent{
+		Type:  logLine,
+		Stage: w.stage,
+		Step:  w.step,
+		Line:  string(bytes.TrimSuffix(line, []byte("\r"))),
+	})
+}
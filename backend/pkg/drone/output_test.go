@@ -0,0 +1,107 @@
+package drone
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2022, 10, 1, 11, 0, 0, 0, time.UTC)
+	o := newJSONOutput(&buf)
+	o.now = func() time.Time { return now }
+
+	state := &pipeline.State{
+		Build: &drone.Build{},
+		Stage: &drone.Stage{
+			Name: "default",
+			Steps: []*drone.Step{
+				{Name: "build", Status: drone.StatusPending},
+			},
+		},
+	}
+	ctx := context.TODO()
+
+	assert.NoError(t, o.startStage(state))
+	state.Start("build")
+	assert.NoError(t, o.ReportStep(ctx, state, "build"))
+	w := o.Stream(ctx, state, "build")
+	_, err := io.WriteString(w, "go build\r\nok ")
+	assert.NoError(t, err)
+	_, err = io.WriteString(w, "done")
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	state.Finish("build", 2)
+	assert.NoError(t, o.ReportStep(ctx, state, "build"))
+	state.FinishAll()
+	assert.NoError(t, o.ReportStage(ctx, state))
+	assert.NoError(t, o.dumpState(state))
+
+	exitCode := 2
+	want := []outputEvent{
+		{Type: stageStarted, Stage: "default"},
+		{Type: stepStarted, Stage: "default", Step: "build", Status: drone.StatusRunning},
+		{Type: logLine, Stage: "default", Step: "build", Line: "go build"},
+		{Type: logLine, Stage: "default", Step: "build", Line: "ok done"},
+		{Type: stepFinished, Stage: "default", Step: "build", Status: drone.StatusFailing, ExitCode: &exitCode},
+		{Type: stageFinished, Stage: "default", Status: drone.StatusFailing},
+		{Type: stateDumped, Stage: "default"},
+	}
+
+	var got []outputEvent
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e outputEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, now, e.Timestamp)
+		e.Timestamp = time.Time{}
+		if e.State != nil {
+			assert.Equal(t, "build", e.State.Stage.Steps[0].Name)
+			e.State = nil
+		}
+		got = append(got, e)
+	}
+	for i := range want {
+		want[i].Timestamp = time.Time{}
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestLogWriterOverlongLine(t *testing.T) {
+	var buf bytes.Buffer
+	o := newJSONOutput(&buf)
+	state := &pipeline.State{
+		Build: &drone.Build{},
+		Stage: &drone.Stage{Name: "default"},
+	}
+	w := o.Stream(context.TODO(), state, "build")
+	// a progress bar that never ends the line
+	for i := 0; i < 4; i++ {
+		_, err := io.WriteString(w, strings.Repeat("=", maxLineLength/2+1))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	var lengths []int
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e outputEvent
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		lengths = append(lengths, len(e.Line))
+	}
+	assert.Equal(t, []int{maxLineLength, maxLineLength, 4}, lengths)
+}
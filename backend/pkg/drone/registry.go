@@ -0,0 +1,224 @@
+package drone
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/logger"
+	"github.com/drone/runner-go/registry"
+)
+
+// tokenUsername is the username of the credentials of a credential helper that are an identity token,
+// the tokens could not be used as the registry password
+const tokenUsername = "<token>"
+
+// dockerCLIConfig is the part of the docker CLI config file with the registry credentials
+type dockerCLIConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// helperCredentials are the credentials returned by the get of a credential helper
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// credentialHelper runs the action of the docker credential helper e.g. `docker-credential-desktop get`
+// with the input and returns its output
+type credentialHelper func(ctx context.Context, helper, action, input string) ([]byte, error)
+
+// dockerConfig returns a registry credential provider that reads the credentials saved by `docker login`
+// in the docker CLI config file, the credentials kept by the credsStore and the credHelpers of the config
+// are got from the credential helpers. The default config file of the docker CLI is used when the path is
+// empty. A missing or unreadable config never fails the build, there are no credentials instead.
+func dockerConfig(path string) registry.Provider {
+	return &dockerConfigProvider{
+		path:   path,
+		helper: runCredentialHelper,
+	}
+}
+
+type dockerConfigProvider struct {
+	path   string
+	helper credentialHelper
+}
+
+func (p *dockerConfigProvider) List(ctx context.Context, _ *registry.Request) ([]*drone.Registry, error) {
+	log := logger.FromContext(ctx)
+	path := p.path
+	if path == "" {
+		path = defaultDockerConfig()
+	}
+	log = log.WithField("path", path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.WithError(err).Warnln("registry: docker config: cannot read config file")
+		}
+		return nil, nil
+	}
+	config := new(dockerCLIConfig)
+	if err := json.Unmarshal(data, config); err != nil {
+		log.WithError(err).Warnln("registry: docker config: cannot parse config file")
+		return nil, nil
+	}
+
+	// the same as the docker CLI, the credentials of the store override the ones of the
+	// config file and the credentials of the helpers of the registries override both
+	creds := make(map[string]*drone.Registry)
+	for address, auth := range config.Auths {
+		username, password := auth.Username, auth.Password
+		if auth.Auth != "" {
+			username, password = decodeAuth(auth.Auth)
+		}
+		if username == "" && password == "" {
+			continue
+		}
+		creds[registryHostname(address)] = &drone.Registry{
+			Address:  registryHostname(address),
+			Username: username,
+			Password: password,
+		}
+	}
+
+	if config.CredsStore != "" {
+		out, err := p.helper(ctx, config.CredsStore, "list", "")
+		if err != nil {
+			log.WithError(err).Warnf("registry: docker config: cannot list the credentials of %s", config.CredsStore)
+		}
+		addresses := make(map[string]string)
+		if err == nil {
+			if err := json.Unmarshal(out, &addresses); err != nil {
+				log.WithError(err).Warnf("registry: docker config: cannot parse the credentials of %s", config.CredsStore)
+			}
+		}
+		for _, address := range sortedKeys(addresses) {
+			if cred := p.get(ctx, config.CredsStore, address); cred != nil {
+				creds[cred.Address] = cred
+			}
+		}
+	}
+
+	for _, address := range sortedKeys(config.CredHelpers) {
+		if cred := p.get(ctx, config.CredHelpers[address], address); cred != nil {
+			creds[cred.Address] = cred
+		}
+	}
+
+	res := make([]*drone.Registry, 0, len(creds))
+	for _, cred := range creds {
+		log.WithField("address", cred.Address).
+			WithField("username", cred.Username).
+			Traceln("registry: docker config: received credentials")
+		res = append(res, cred)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Address < res[j].Address
+	})
+	return res, nil
+}
+
+// get gets the credentials of the registry from the credential helper, nil when there are none
+func (p *dockerConfigProvider) get(ctx context.Context, helper, address string) *drone.Registry {
+	log := logger.FromContext(ctx).
+		WithField("helper", helper).
+		WithField("address", address)
+	out, err := p.helper(ctx, helper, "get", address)
+	if err != nil {
+		log.WithError(err).Debugln("registry: docker config: no credentials from the credential helper")
+		return nil
+	}
+	cred := new(helperCredentials)
+	if err := json.Unmarshal(out, cred); err != nil {
+		log.WithError(err).Warnln("registry: docker config: cannot parse the credentials of the credential helper")
+		return nil
+	}
+	if cred.Username == tokenUsername || cred.Secret == "" {
+		log.Debugln("registry: docker config: skipping the identity token of the credential helper")
+		return nil
+	}
+	return &drone.Registry{
+		Address:  registryHostname(address),
+		Username: cred.Username,
+		Password: cred.Secret,
+	}
+}
+
+// runCredentialHelper runs the docker-credential-<helper> binary, the same way the docker CLI does
+func runCredentialHelper(ctx context.Context, helper, action, input string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := osexec.CommandContext(ctx, "docker-credential-"+helper, action)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// the helpers write the errors e.g. `credentials not found in native keychain` to the stdout
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if msg == "" {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w, %s", err, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// defaultDockerConfig is the config file of the docker CLI, in the DOCKER_CONFIG folder when it is set
+func defaultDockerConfig() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// decodeAuth decodes the base64 encoded username:password of the auth of the docker config
+func decodeAuth(s string) (username, password string) {
+	d, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", ""
+	}
+	username, password, _ = strings.Cut(string(d), ":")
+	return username, password
+}
+
+// registryHostname is the hostname of the registry address e.g. index.docker.io for https://index.docker.io/v1/
+func registryHostname(address string) string {
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return strings.TrimSuffix(address, "/")
+}
+
+// sortedKeys gets the keys of the map in order, for the credentials to be got in the same order on each build
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
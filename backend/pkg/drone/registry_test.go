@@ -0,0 +1,85 @@
+package drone
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/stretchr/testify/assert"
+)
+
+const dockerConfigFile = `{
+  "auths": {
+    "https://index.docker.io/v1/": {},
+    "ghcr.io": {},
+    "registry.example.com": {
+      "auth": "%s"
+    },
+    "quay.io": {
+      "auth": "%s"
+    }
+  },
+  "credsStore": "desktop",
+  "credHelpers": {
+    "123456789012.dkr.ecr.us-east-1.amazonaws.com": "ecr-login",
+    "quay.io": "quay"
+  }
+}`
+
+func TestDockerConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	auth := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	config := []byte(fmt.Sprintf(dockerConfigFile, auth("jane:s3cr3t"), auth("jane:old")))
+	if err := os.WriteFile(path, config, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	helpers := map[string]map[string]string{
+		"desktop": {
+			"list":                        `{"https://index.docker.io/v1/":"jane","ghcr.io":"<token>"}`,
+			"https://index.docker.io/v1/": `{"ServerURL":"https://index.docker.io/v1/","Username":"jane","Secret":"hub"}`,
+			"ghcr.io":                     `{"ServerURL":"ghcr.io","Username":"<token>","Secret":"identity"}`,
+		},
+		"ecr-login": {
+			"123456789012.dkr.ecr.us-east-1.amazonaws.com": `{"Username":"AWS","Secret":"ecr"}`,
+		},
+	}
+	p := &dockerConfigProvider{
+		path: path,
+		helper: func(_ context.Context, helper, action, input string) ([]byte, error) {
+			key := input
+			if action == "list" {
+				key = action
+			}
+			out, ok := helpers[helper][key]
+			if !ok {
+				return nil, errors.New("credentials not found in native keychain")
+			}
+			return []byte(out), nil
+		},
+	}
+
+	got, err := p.List(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []*drone.Registry{
+		{Address: "123456789012.dkr.ecr.us-east-1.amazonaws.com", Username: "AWS", Password: "ecr"},
+		{Address: "index.docker.io", Username: "jane", Password: "hub"},
+		// the credentials of the config are kept when its credential helper has none
+		{Address: "quay.io", Username: "jane", Password: "old"},
+		{Address: "registry.example.com", Username: "jane", Password: "s3cr3t"},
+	}, got)
+
+	// no credentials and no error without a config file
+	p.path = filepath.Join(dir, "missing.json")
+	got, err = p.List(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+}
@@ -0,0 +1,102 @@
+package drone
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/sirupsen/logrus"
+)
+
+// Options configures the pipeline run that is started from the backend,
+// they are the counterparts of the exec command flags
+type Options struct {
+	// PipelineFile is the absolute path of the pipeline file
+	PipelineFile string
+	// Config is the content of the pipeline file, the PipelineFile is read when not set
+	Config []byte
+	// Stage is the name of the pipeline to execute
+	Stage string
+	// Include is the list of steps to include
+	Include []string
+	// Exclude is the list of steps to exclude
+	Exclude []string
+	// ResumeAt is the name of the step to resume the pipeline at
+	ResumeAt string
+	// SecretFile is the file defining values that can be used with from_secret
+	SecretFile string
+	// EnvFile is the file defining the environment variables of the steps
+	EnvFile string
+	// Trusted marks the build as trusted
+	Trusted bool
+	// Network is the docker network to attach the step containers to
+	Network string
+	// Timeout is the build timeout, defaults to an hour
+	Timeout time.Duration
+	// RunKey identifies the run that the step containers belong to
+	RunKey string
+	// Reporter reports the stage and step state changes, defaults to none
+	Reporter pipeline.Reporter
+	// Streamer streams the step logs, defaults to the console
+	Streamer pipeline.Streamer
+}
+
+// toExecCommand builds the execCommand from the options the same way as
+// the exec command flags are mapped
+func (o Options) toExecCommand() *execCommand {
+	timeout := o.Timeout
+	if timeout == 0 {
+		timeout = time.Hour
+	}
+	// the build is a push of the local git checkout, the same as the exec command defaults to
+	git := readGitInfo(filepath.Dir(o.PipelineFile))
+	commy := &execCommand{
+		Flags: &Flags{
+			Build: &drone.Build{
+				Event:  drone.EventPush,
+				Ref:    git.Ref,
+				After:  git.Sha,
+				Source: git.Branch,
+				Target: git.Branch,
+			},
+			Repo: &drone.Repo{
+				Trusted: o.Trusted,
+				Timeout: int64(timeout.Minutes()),
+				Branch:  git.Branch,
+			},
+			Stage: &drone.Stage{
+				Name: o.Stage,
+			},
+			Netrc:  &drone.Netrc{},
+			System: &drone.System{},
+		},
+		Source:     o.PipelineFile,
+		RawSource:  o.Config,
+		Workspace:  filepath.Dir(o.PipelineFile),
+		Include:    o.Include,
+		Exclude:    o.Exclude,
+		ResumeAt:   o.ResumeAt,
+		Environ:    readParams(o.EnvFile),
+		Volumes:    map[string]string{},
+		Privileged: defaultPrivileged,
+		RunKey:     o.RunKey,
+		reporter:   o.Reporter,
+		streamer:   o.Streamer,
+	}
+	commy.Envs = getEnv(commy.Flags)
+	if o.Network != "" {
+		commy.Networks = []string{o.Network}
+	}
+	if o.SecretFile != "" {
+		commy.SecretSources = []secretSource{dotenvSecrets(o.SecretFile)}
+	}
+	return commy
+}
+
+// Run executes the pipeline stage as configured by the options, blocking until the run is
+// done or the context is cancelled. The returned state is nil when the stage could not be compiled.
+func Run(ctx context.Context, log *logrus.Logger, opts Options) (*pipeline.State, error) {
+	return opts.toExecCommand().run(ctx, log)
+}
@@ -0,0 +1,91 @@
+package drone
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const pushPipeline = `
+kind: pipeline
+type: docker
+name: default
+
+trigger:
+  event:
+  - push
+  branch:
+  - main
+
+steps:
+- name: build
+  image: busybox
+  commands:
+  - echo $DRONE_BRANCH
+  when:
+    event:
+    - push
+`
+
+func TestOptionsTimeout(t *testing.T) {
+	// the repo timeout is in minutes
+	assert.Equal(t, int64(60), Options{}.toExecCommand().Repo.Timeout)
+	assert.Equal(t, int64(90), Options{Timeout: 90 * time.Minute}.toExecCommand().Repo.Timeout)
+}
+
+func TestOptionsNetwork(t *testing.T) {
+	assert.Empty(t, Options{}.toExecCommand().Networks)
+	assert.Equal(t, []string{"my-network"}, Options{Network: "my-network"}.toExecCommand().Networks)
+}
+
+func TestOptionsPush(t *testing.T) {
+	// the environment of the backend is not to leak into the test
+	for _, env := range []string{"DRONE_EVENT", "DRONE_BRANCH", "DRONE_COMMIT_REF", "DRONE_COMMIT_SHA"} {
+		t.Setenv(env, "")
+	}
+	dir := t.TempDir()
+	git := gitCommand(t, dir)
+	git("init", "--quiet", "--initial-branch", "main")
+	git("commit", "--quiet", "--allow-empty", "--message", "initial")
+	sha := git("rev-parse", "HEAD")
+	sha = sha[:len(sha)-1]
+
+	commy := Options{
+		PipelineFile: filepath.Join(dir, ".drone.yml"),
+		Config:       []byte(pushPipeline),
+		Stage:        "default",
+	}.toExecCommand()
+	assert.Equal(t, &drone.Build{
+		Event:  drone.EventPush,
+		Ref:    "refs/heads/main",
+		After:  sha,
+		Source: "main",
+		Target: "main",
+	}, commy.Build)
+	assert.Equal(t, "main", commy.Repo.Branch)
+	for k, v := range map[string]string{
+		"DRONE_EVENT":      "push",
+		"DRONE_BRANCH":     "main",
+		"DRONE_COMMIT_REF": "refs/heads/main",
+		"DRONE_COMMIT_SHA": sha,
+	} {
+		assert.Equalf(t, v, commy.Envs[k], "Expecting %s to be %s", k, v)
+	}
+
+	spec, err := commy.compile(logrus.New())
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, step := range commy.Stage.Steps {
+		assert.Equal(t, drone.StatusPending, step.Status, step.Name)
+	}
+	for _, step := range spec.Steps {
+		if step.Name == "build" {
+			assert.Equal(t, "main", step.Envs["DRONE_BRANCH"])
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package drone
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"runtime"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/joho/godotenv"
+)
+
+// secretSource is a source of the values of the secrets referenced with from_secret
+type secretSource interface {
+	// secrets loads all the secrets of the source by their names
+	secrets(ctx context.Context) (map[string]string, error)
+	// String describes the source in the errors
+	String() string
+}
+
+// staticSecrets are the secrets by their names
+type staticSecrets map[string]string
+
+func (s staticSecrets) secrets(_ context.Context) (map[string]string, error) {
+	return s, nil
+}
+
+func (s staticSecrets) String() string {
+	return "static secrets"
+}
+
+// dotenvSecrets is the dotenv file with the secrets
+type dotenvSecrets string
+
+func (f dotenvSecrets) secrets(_ context.Context) (map[string]string, error) {
+	secrets, err := godotenv.Read(string(f))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the secret file %s, %w", string(f), err)
+	}
+	return secrets, nil
+}
+
+func (f dotenvSecrets) String() string {
+	return "secret file " + string(f)
+}
+
+// envSecrets are the environment variables with the prefix, the secrets are named by the rest of the variable
+// name e.g. DRONE_SECRET_GITHUB_TOKEN is the secret github_token with the prefix DRONE_SECRET_
+type envSecrets string
+
+func (prefix envSecrets) secrets(_ context.Context) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if name := strings.TrimPrefix(k, string(prefix)); name != k && name != "" {
+			secrets[name] = v
+		}
+	}
+	return secrets, nil
+}
+
+func (prefix envSecrets) String() string {
+	return fmt.Sprintf("environment %s*", string(prefix))
+}
+
+// ageSecrets is the dotenv file with the secrets encrypted with age, either binary or armored, it
+// is decrypted with the identities of the identity file e.g. the key file of age-keygen
+type ageSecrets struct {
+	file     string
+	identity string
+}
+
+func (a ageSecrets) secrets(_ context.Context) (map[string]string, error) {
+	if a.identity == "" {
+		return nil, fmt.Errorf("unable to decrypt the secret file %s, no age identity file", a.file)
+	}
+	keys, err := os.Open(a.identity)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the age identity file %s, %w", a.identity, err)
+	}
+	defer keys.Close()
+	identities, err := age.ParseIdentities(keys)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the age identity file %s, %w", a.identity, err)
+	}
+
+	f, err := os.Open(a.file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the encrypted secret file %s, %w", a.file, err)
+	}
+	defer f.Close()
+	var in io.Reader = bufio.NewReader(f)
+	if peek, _ := in.(*bufio.Reader).Peek(len(armor.Header)); string(peek) == armor.Header {
+		in = armor.NewReader(in)
+	}
+	r, err := age.Decrypt(in, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the secret file %s, %w", a.file, err)
+	}
+	secrets, err := godotenv.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the decrypted secret file %s, %w", a.file, err)
+	}
+	return secrets, nil
+}
+
+func (a ageSecrets) String() string {
+	return "encrypted secret file " + a.file
+}
+
+// commandSecrets is the helper command that writes the secrets to the stdout as a JSON object
+// e.g. `{"github_token":"..."}`, it is run with the shell
+type commandSecrets string
+
+func (c commandSecrets) secrets(ctx context.Context) (map[string]string, error) {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := osexec.CommandContext(ctx, shell, flag, string(c))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unable to run the secret command %q, %w %s", string(c), err, strings.TrimSpace(stderr.String()))
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(stdout.Bytes(), &secrets); err != nil {
+		return nil, fmt.Errorf("unable to parse the output of the secret command %q, the output should be a JSON object of strings, %w", string(c), err)
+	}
+	return secrets, nil
+}
+
+func (c commandSecrets) String() string {
+	return fmt.Sprintf("secret command %q", string(c))
+}
+
+// SecretOptions are the sources of the values of the from_secret references, the counterparts of
+// the secret flags
+type SecretOptions struct {
+	// File is the dotenv file with the secrets
+	File string
+	// EnvPrefix is the prefix of the environment variables with the secrets
+	EnvPrefix string
+	// AgeFile is the dotenv file with the secrets encrypted with age
+	AgeFile string
+	// AgeIdentity is the age identity file to decrypt the AgeFile
+	AgeIdentity string
+	// Command is the command that writes the secrets as a JSON object
+	Command string
+}
+
+// sources stacks the secret sources of the options, the files take precedence over the
+// command and the environment
+func (o SecretOptions) sources() []secretSource {
+	var sources []secretSource
+	if o.File != "" {
+		sources = append(sources, dotenvSecrets(o.File))
+	}
+	if o.AgeFile != "" {
+		sources = append(sources, ageSecrets{
+			file:     o.AgeFile,
+			identity: o.AgeIdentity,
+		})
+	}
+	if o.Command != "" {
+		sources = append(sources, commandSecrets(o.Command))
+	}
+	if o.EnvPrefix != "" {
+		sources = append(sources, envSecrets(o.EnvPrefix))
+	}
+	return sources
+}
+
+// loadSecrets stacks the secrets of the sources, the first source with a secret wins
+func loadSecrets(ctx context.Context, sources []secretSource) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, source := range sources {
+		s, err := source.secrets(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range s {
+			// the secrets are matched ignoring the case, the same as the compiler does
+			name = strings.ToLower(name)
+			if _, ok := secrets[name]; !ok {
+				secrets[name] = value
+			}
+		}
+	}
+	return secrets, nil
+}
+
+// unresolvedSecrets lists the from_secret references of the pipeline steps that are not in the secrets,
+// the steps that are not going to run are ignored
+func unresolvedSecrets(p *resource.Pipeline, secrets map[string]string, skipped map[string]bool) []string {
+	var unresolved []string
+	resolved := func(name string) bool {
+		_, ok := secrets[strings.ToLower(name)]
+		return ok
+	}
+	for _, name := range p.PullSecrets {
+		if !resolved(name) {
+			unresolved = append(unresolved, fmt.Sprintf("image_pull_secrets %s", name))
+		}
+	}
+	for _, step := range append(append([]*resource.Step{}, p.Services...), p.Steps...) {
+		if step == nil || skipped[step.Name] {
+			continue
+		}
+		var refs []string
+		for env, v := range step.Environment {
+			if v != nil && v.Secret != "" && !resolved(v.Secret) {
+				refs = append(refs, fmt.Sprintf("step %s environment %s from_secret %s", step.Name, env, v.Secret))
+			}
+		}
+		for setting, v := range step.Settings {
+			if v != nil && v.Secret != "" && !resolved(v.Secret) {
+				refs = append(refs, fmt.Sprintf("step %s setting %s from_secret %s", step.Name, setting, v.Secret))
+			}
+		}
+		sort.Strings(refs)
+		unresolved = append(unresolved, refs...)
+	}
+	return unresolved
+}
+
+// secretSources describes the sources for the errors
+func secretSources(sources []secretSource) string {
+	if len(sources) == 0 {
+		return "no secret sources, set one with --secret-file, --secret-env-prefix, --secret-age-file or --secret-command"
+	}
+	names := make([]string, len(sources))
+	for i, source := range sources {
+		names[i] = source.String()
+	}
+	return "looked up in " + strings.Join(names, ", ")
+}
@@ -0,0 +1,130 @@
+package drone
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/drone-runners/drone-runner-docker/engine/resource"
+	"github.com/drone/runner-go/manifest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const secretsPipeline = `
+kind: pipeline
+type: docker
+name: default
+
+image_pull_secrets:
+- dockerconfig
+
+steps:
+- name: build
+  image: golang:1.19
+  environment:
+    TOKEN:
+      from_secret: token
+    PASSWORD:
+      from_secret: password
+- name: publish
+  image: plugins/docker
+  settings:
+    password:
+      from_secret: registry_password
+  when:
+    branch:
+    - release
+`
+
+func TestLoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	dotenv := filepath.Join(dir, "secrets.env")
+	if err := os.WriteFile(dotenv, []byte("TOKEN=from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(dir, "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	a := armor.NewWriter(&encrypted)
+	w, err := age.Encrypt(a, identity.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("TOKEN=from-age\nPASSWORD=s3cr3t\n"))
+	w.Close()
+	a.Close()
+	ageFile := filepath.Join(dir, "secrets.env.age")
+	if err := os.WriteFile(ageFile, encrypted.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_SECRET_TOKEN", "from-env")
+	t.Setenv("TEST_SECRET_Api_Key", "api")
+
+	sources := []secretSource{
+		dotenvSecrets(dotenv),
+		ageSecrets{file: ageFile, identity: identityFile},
+		envSecrets("TEST_SECRET_"),
+	}
+	if runtime.GOOS != "windows" {
+		sources = append(sources, commandSecrets(`echo '{"token":"from-command","registry_password":"hunter2"}'`))
+	}
+
+	got, err := loadSecrets(context.Background(), sources)
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", got["token"])
+	assert.Equal(t, "s3cr3t", got["password"])
+	assert.Equal(t, "api", got["api_key"])
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, "hunter2", got["registry_password"])
+	}
+
+	_, err = loadSecrets(context.Background(), []secretSource{dotenvSecrets(filepath.Join(dir, "missing.env"))})
+	assert.ErrorContains(t, err, "unable to read the secret file")
+
+	_, err = loadSecrets(context.Background(), []secretSource{ageSecrets{file: ageFile}})
+	assert.EqualError(t, err, "unable to decrypt the secret file "+ageFile+", no age identity file")
+}
+
+func TestUnresolvedSecrets(t *testing.T) {
+	m, err := manifest.ParseString(secretsPipeline)
+	if !assert.NoError(t, err) {
+		return
+	}
+	p := m.Resources[0].(*resource.Pipeline)
+
+	assert.Equal(t, []string{
+		"image_pull_secrets dockerconfig",
+		"step build environment PASSWORD from_secret password",
+		"step build environment TOKEN from_secret token",
+		"step publish setting password from_secret registry_password",
+	}, unresolvedSecrets(p, map[string]string{}, nil))
+
+	assert.Empty(t, unresolvedSecrets(p, map[string]string{
+		"dockerconfig": "{}",
+		"token":        "t",
+		"password":     "p",
+	}, map[string]bool{"publish": true}))
+
+	commy := Options{
+		PipelineFile: "/tmp/examples/secrets/.drone.yml",
+		Config:       []byte(secretsPipeline),
+		Stage:        "default",
+	}.toExecCommand()
+	commy.SecretSources = []secretSource{staticSecrets{"dockerconfig": "{}", "TOKEN": "t"}}
+	_, err = commy.compile(logrus.New())
+	assert.EqualError(t, err, "unresolved secrets in stage default: step build environment PASSWORD from_secret password; looked up in static secrets")
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithBufferSize sets the number of events buffered for each subscriber,
+// events are dropped for the subscribers that are not keeping up
+func WithBufferSize(size int) Option {
+	return func(b *Broker) {
+		if size > 0 {
+			b.bufferSize = size
+		}
+	}
+}
+
+// WithFallback sets the function that is called on the status events in addition to the
+// subscribers e.g. to notify the clients that can't subscribe to the events. The fallback is
+// called asynchronously, the events published while it runs result in a single call.
+func WithFallback(fallback func(ctx context.Context) error) Option {
+	return func(b *Broker) {
+		b.fallback = fallback
+	}
+}
+
+// NewBroker creates a new events Broker
+func NewBroker(ctx context.Context, log *logrus.Logger, options ...Option) *Broker {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	b := &Broker{
+		Ctx:         ctx,
+		Log:         log,
+		subscribers: make(map[chan Event]struct{}),
+		bufferSize:  64,
+	}
+	for _, o := range options {
+		o(b)
+	}
+	if b.fallback != nil {
+		b.fallbackCh = make(chan struct{}, 1)
+		go b.runFallback()
+	}
+	return b
+}
+
+// Subscribe registers a new subscriber. The returned function has to be called
+// to unsubscribe and release the channel.
+func (b *Broker) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, b.bufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish sends the event to all the subscribers without blocking
+func (b *Broker) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	b.mu.RLock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			b.Log.Debugf("Dropping event %s of stage %d for a slow subscriber", e.Type, e.StageID)
+		}
+	}
+	b.mu.RUnlock()
+
+	if b.fallback != nil && e.Type != LogAppended {
+		select {
+		case b.fallbackCh <- struct{}{}:
+		default:
+			// a call of the fallback is already pending
+		}
+	}
+}
+
+// runFallback calls the fallback for the pending status events until the context is done
+func (b *Broker) runFallback() {
+	for {
+		select {
+		case <-b.Ctx.Done():
+			return
+		case <-b.fallbackCh:
+			if err := b.fallback(b.Ctx); err != nil {
+				b.Log.Errorf("Error notifying the status events, %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package events
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBroker(t *testing.T) {
+	log := utils.LogSetup(os.Stdout, "debug")
+	fallbacks := make(chan struct{}, 10)
+	b := NewBroker(context.TODO(), log,
+		WithBufferSize(1),
+		WithFallback(func(ctx context.Context) error {
+			fallbacks <- struct{}{}
+			return nil
+		}))
+
+	ch, unsubscribe := b.Subscribe()
+
+	b.Publish(Event{Type: StepStarted, StageID: 1, StepName: "build", Status: db.Running})
+	// dropped as the subscriber buffer is full
+	b.Publish(Event{Type: LogAppended, StageID: 1, StepName: "build", Data: "hello"})
+
+	got := <-ch
+	assert.Equal(t, StepStarted, got.Type)
+	assert.Equal(t, "build", got.StepName)
+	assert.False(t, got.Timestamp.IsZero(), "Expecting event timestamp to be set")
+	waitFallback(t, fallbacks)
+	assert.Len(t, fallbacks, 0, "Expecting fallback to be called only for status events")
+
+	unsubscribe()
+	_, ok := <-ch
+	assert.False(t, ok, "Expecting channel to be closed after unsubscribe")
+
+	// publishing without subscribers should not block
+	b.Publish(Event{Type: StageStatusChanged, StageID: 1, Status: db.Success})
+	waitFallback(t, fallbacks)
+}
+
+func TestBrokerFallbackCoalesced(t *testing.T) {
+	log := utils.LogSetup(os.Stdout, "debug")
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	release := make(chan struct{})
+	fallbacks := make(chan struct{}, 10)
+	b := NewBroker(ctx, log,
+		WithFallback(func(ctx context.Context) error {
+			fallbacks <- struct{}{}
+			<-release
+			return nil
+		}))
+
+	b.Publish(Event{Type: StepStarted, StageID: 1, StepName: "build", Status: db.Running})
+	waitFallback(t, fallbacks)
+
+	// publishing does not wait for the running fallback, the events are coalesced into a single call
+	for i := 0; i < 5; i++ {
+		b.Publish(Event{Type: StepFinished, StageID: 1, StepName: "build", Status: db.Success})
+	}
+	close(release)
+	waitFallback(t, fallbacks)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, fallbacks, 0, "Expecting the pending events to be notified once")
+}
+
+func waitFallback(t *testing.T, fallbacks <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-fallbacks:
+	case <-time.After(time.Second):
+		t.Fatal("Expecting the fallback to be called")
+	}
+}
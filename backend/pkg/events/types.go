@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/sirupsen/logrus"
+)
+
+// Type is the type of the event
+type Type string
+
+const (
+	//StageStatusChanged is published when the status of a stage changes
+	StageStatusChanged Type = "stage-status-changed"
+	//StepStarted is published when a step starts to run
+	StepStarted Type = "step-started"
+	//StepFinished is published when a step is done running
+	StepFinished Type = "step-finished"
+	//LogAppended is published when the new log content of a step is saved
+	LogAppended Type = "log-appended"
+	//PipelineChanged is published when the stages of a pipeline file are updated from the file,
+	//the data is the pipeline file
+	PipelineChanged Type = "pipeline-changed"
+)
+
+// Event is the message published to the subscribers
+type Event struct {
+	Type     Type      `json:"type"`
+	StageID  int       `json:"stageId"`
+	StepID   int       `json:"stepId,omitempty"`
+	StepName string    `json:"stepName,omitempty"`
+	Status   db.Status `json:"status"`
+	// Data carries the content of the event e.g. the appended log content
+	Data      string    `json:"data,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Broker publishes the events to its subscribers
+type Broker struct {
+	Ctx         context.Context
+	Log         *logrus.Logger
+	mu          sync.RWMutex
+	subscribers map[chan Event]struct{}
+	fallback    func(ctx context.Context) error
+	bufferSize  int
+	// fallbackCh holds the pending call of the fallback, the status events published
+	// while the fallback is running are coalesced into a single call
+	fallbackCh chan struct{}
+}
+
+// Option configures the Broker
+type Option func(*Broker)
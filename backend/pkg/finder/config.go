@@ -0,0 +1,66 @@
+package finder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFile is the name of the config file that is looked up in the root directory
+const ConfigFile = ".drone-finder.yml"
+
+// Config is the config file of the Finder e.g.
+//
+//	patterns:
+//	- "*.drone.yml"
+//	- .drone.yaml
+//	- ci/*.yml
+//	maxDepth: 3
+//	followSymlinks: true
+type Config struct {
+	Patterns       []string `yaml:"patterns"`
+	MaxDepth       *int     `yaml:"maxDepth"`
+	FollowSymlinks *bool    `yaml:"followSymlinks"`
+	Workers        int      `yaml:"workers"`
+}
+
+// ReadConfig reads the config file, the ConfigFile of the root directory when the file is empty.
+// No config is returned when the ConfigFile of the root directory does not exist.
+func ReadConfig(root, file string) (*Config, error) {
+	if file == "" {
+		file = filepath.Join(root, ConfigFile)
+		if _, err := os.Stat(file); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("unable to parse the config file %s, %w", file, err)
+	}
+	return c, nil
+}
+
+// Options are the options of the settings of the config
+func (c *Config) Options() []Option {
+	if c == nil {
+		return nil
+	}
+	opts := []Option{
+		WithPatterns(c.Patterns...),
+		WithWorkers(c.Workers),
+	}
+	if c.MaxDepth != nil {
+		opts = append(opts, WithMaxDepth(*c.MaxDepth))
+	}
+	if c.FollowSymlinks != nil {
+		opts = append(opts, WithFollowSymlinks(*c.FollowSymlinks))
+	}
+	return opts
+}
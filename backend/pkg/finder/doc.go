@@ -0,0 +1,21 @@
+/*
+Copyright 2022 Kamesh Sampath<kamesh.sampath@hotmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package finder discovers the pipeline files under a directory, the names of the files are matched with
+// glob patterns e.g. *.drone.yml or ci/*.yml. The directories are walked concurrently, skipping the ones
+// ignored by the .dockerignore of the directory, down to an optional max depth. The symbolic links to the
+// directories are followed only when configured, each directory is walked once to not loop.
+package finder
@@ -0,0 +1,321 @@
+package finder
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/ignore"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPatterns are the patterns of the pipeline files of the formats known to the converter
+var DefaultPatterns = []string{"*.drone.yml", "*.drone.jsonnet", "*.drone.star"}
+
+// Finder finds the pipeline files under the root directory
+type Finder struct {
+	root           string
+	patterns       []string
+	maxDepth       int
+	followSymlinks bool
+	workers        int
+	log            *log.Logger
+	ignorer        ignore.FileIgnorer
+
+	// visited maps the real paths of the directories walked when following the links to the path they
+	// are walked through, the lexically first of the paths for the files to be found with the same path
+	visitedMu sync.Mutex
+	visited   map[string]string
+}
+
+// Option configures the Finder
+type Option func(*Finder)
+
+// WithPatterns sets the glob patterns of the pipeline files, the patterns with a / are matched against the
+// slash separated path relative to the root e.g. ci/*.yml, the others against the file name e.g. *.drone.yml
+func WithPatterns(patterns ...string) Option {
+	return func(f *Finder) {
+		if len(patterns) > 0 {
+			f.patterns = patterns
+		}
+	}
+}
+
+// WithMaxDepth sets the depth of the directories to look in, the files of the root are at depth 0
+// and the ones of its directories at depth 1. A negative depth is unlimited.
+func WithMaxDepth(depth int) Option {
+	return func(f *Finder) {
+		f.maxDepth = depth
+	}
+}
+
+// WithFollowSymlinks sets whether the symbolic links to the directories are walked, the symbolic
+// links to the files are always matched
+func WithFollowSymlinks(follow bool) Option {
+	return func(f *Finder) {
+		f.followSymlinks = follow
+	}
+}
+
+// WithWorkers sets the number of the directories read concurrently, defaults to 4 per CPU
+func WithWorkers(workers int) Option {
+	return func(f *Finder) {
+		if workers > 0 {
+			f.workers = workers
+		}
+	}
+}
+
+// WithLogger sets the logger of the directories that could not be walked
+func WithLogger(logger *log.Logger) Option {
+	return func(f *Finder) {
+		if logger != nil {
+			f.log = logger
+		}
+	}
+}
+
+// New creates a new Finder of the pipeline files under the root directory
+func New(root string, options ...Option) (*Finder, error) {
+	f := &Finder{
+		root:     filepath.Clean(root),
+		patterns: DefaultPatterns,
+		maxDepth: -1,
+		workers:  4 * runtime.NumCPU(),
+		log:      log.StandardLogger(),
+		visited:  make(map[string]string),
+	}
+	for _, o := range options {
+		o(f)
+	}
+
+	for _, p := range f.patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q, %w", p, err)
+		}
+	}
+
+	ignorer, err := ignore.NewOrDefault(f.root)
+	if err != nil {
+		return nil, err
+	}
+	f.ignorer = ignorer
+
+	return f, nil
+}
+
+// Find walks the root directory and returns the pipeline files in lexical order
+func (f *Finder) Find() ([]string, error) {
+	info, err := os.Stat(f.root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", f.root)
+	}
+	w := &walk{
+		sem: make(chan struct{}, f.workers),
+	}
+	w.wg.Add(1)
+	go f.walkDir(w, f.root, 0)
+	w.wg.Wait()
+
+	if w.err != nil {
+		return nil, w.err
+	}
+	files := make([]string, 0, len(w.found))
+	for _, fd := range w.found {
+		// the directory was walked again through a lexically first path
+		if f.followSymlinks && f.walkedAs(fd.real) != fd.dir {
+			continue
+		}
+		files = append(files, fd.file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// walk is the state of a Find
+type walk struct {
+	wg sync.WaitGroup
+	// sem limits the directories read concurrently
+	sem   chan struct{}
+	mu    sync.Mutex
+	found []found
+	err   error
+}
+
+// found is a pipeline file found in the directory dir, real is the real path of dir when following the links
+type found struct {
+	file string
+	dir  string
+	real string
+}
+
+func (w *walk) add(fd found) {
+	w.mu.Lock()
+	w.found = append(w.found, fd)
+	w.mu.Unlock()
+}
+
+func (w *walk) fail(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+}
+
+// walkDir matches the files of the directory and walks its directories concurrently, the files of
+// the directory are at the depth
+func (f *Finder) walkDir(w *walk, dir string, depth int) {
+	defer w.wg.Done()
+
+	var real string
+	if f.followSymlinks {
+		var ok bool
+		if real, ok = f.visit(dir); !ok {
+			return
+		}
+	}
+
+	w.sem <- struct{}{}
+	entries, err := os.ReadDir(dir)
+	<-w.sem
+	if err != nil {
+		f.log.Warnf("Skipping directory %s, %v", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		info, err := f.entryInfo(p, entry)
+		if err != nil {
+			f.log.Warnf("Skipping %s, %v", p, err)
+			continue
+		}
+		// the links to the directories that are not followed
+		if info.Mode()&fs.ModeSymlink != 0 {
+			continue
+		}
+
+		ignorable, err := f.ignorer.CanIgnore(p, info)
+		if err != nil {
+			w.fail(err)
+			return
+		}
+
+		if !info.IsDir() {
+			if f.matches(p) {
+				w.add(found{file: p, dir: dir, real: real})
+			}
+			continue
+		}
+
+		if ignorable == ignore.Transitive {
+			continue
+		}
+		if f.maxDepth >= 0 && depth+1 > f.maxDepth {
+			continue
+		}
+		if entry.Type()&fs.ModeSymlink != 0 && f.insideRoot(p) {
+			continue
+		}
+		w.wg.Add(1)
+		go f.walkDir(w, p, depth+1)
+	}
+}
+
+// entryInfo is the file info of the entry, the symbolic links are resolved to their targets. The links
+// to the directories are reported as links when they are not followed.
+func (f *Finder) entryInfo(p string, entry fs.DirEntry) (fs.FileInfo, error) {
+	if entry.Type()&fs.ModeSymlink == 0 {
+		return entry.Info()
+	}
+	target, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if target.IsDir() && !f.followSymlinks {
+		return entry.Info()
+	}
+	return target, nil
+}
+
+// insideRoot reports whether the linked directory is inside the root, it is walked as the directory
+// rather than through the link for the files to be found once with the same path
+func (f *Finder) insideRoot(link string) bool {
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return true
+	}
+	root, err := filepath.EvalSymlinks(f.root)
+	if err != nil {
+		root = f.root
+	}
+	rel, err := filepath.Rel(root, target)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// visit marks the real directory of dir as walked through dir and returns the real directory. It is false
+// when the directory was already walked through a lexically first path e.g. the links of a loop, the
+// directory is walked again when dir comes first so that the paths found do not depend on the walk order.
+func (f *Finder) visit(dir string) (string, bool) {
+	real := dir
+	if p, err := filepath.EvalSymlinks(dir); err == nil {
+		real = p
+	}
+	f.visitedMu.Lock()
+	defer f.visitedMu.Unlock()
+	if walked, ok := f.visited[real]; ok && !pathLess(dir, walked) {
+		return real, false
+	}
+	f.visited[real] = dir
+	return real, true
+}
+
+// walkedAs is the path the real directory was last walked through
+func (f *Finder) walkedAs(real string) string {
+	f.visitedMu.Lock()
+	defer f.visitedMu.Unlock()
+	return f.visited[real]
+}
+
+// pathLess compares the paths element by element, so that a directory walked through a lexically first
+// path has its subdirectories walked through lexically first paths as well
+func pathLess(a, b string) bool {
+	as := strings.Split(filepath.ToSlash(a), "/")
+	bs := strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// matches reports whether the file matches any of the patterns
+func (f *Finder) matches(file string) bool {
+	rel, err := filepath.Rel(f.root, file)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	name := path.Base(rel)
+	for _, p := range f.patterns {
+		subject := name
+		if strings.Contains(p, "/") {
+			subject = rel
+		}
+		if ok, _ := path.Match(p, subject); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tree creates the files under the dir, the names are slash separated
+func tree(t *testing.T, dir string, files ...string) {
+	for _, f := range files {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("kind: pipeline\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	root := t.TempDir()
+	tree(t, root,
+		".drone.yml",
+		"api/.drone.yml",
+		"api/deploy.drone.yml",
+		"web/.drone.jsonnet",
+		"web/ci/build.yml",
+		"web/ci/nested/test.yml",
+		"libs/a/b/c/.drone.star",
+		"node_modules/dep/.drone.yml",
+		"README.md",
+	)
+
+	rel := func(files []string) []string {
+		var r []string
+		for _, f := range files {
+			p, _ := filepath.Rel(root, f)
+			r = append(r, filepath.ToSlash(p))
+		}
+		return r
+	}
+
+	findTests := map[string]struct {
+		opts []Option
+		want []string
+	}{
+		"defaults": {
+			want: []string{".drone.yml", "api/.drone.yml", "api/deploy.drone.yml", "libs/a/b/c/.drone.star", "web/.drone.jsonnet"},
+		},
+		"maxDepth": {
+			opts: []Option{WithMaxDepth(1)},
+			want: []string{".drone.yml", "api/.drone.yml", "api/deploy.drone.yml", "web/.drone.jsonnet"},
+		},
+		"rootOnly": {
+			opts: []Option{WithMaxDepth(0)},
+			want: []string{".drone.yml"},
+		},
+		"patterns": {
+			opts: []Option{WithPatterns(".drone.yml", "web/ci/*.yml"), WithWorkers(1)},
+			want: []string{".drone.yml", "api/.drone.yml", "web/ci/build.yml"},
+		},
+	}
+
+	for name, tc := range findTests {
+		t.Run(name, func(t *testing.T) {
+			f, err := New(root, tc.opts...)
+			if !assert.NoError(t, err) {
+				return
+			}
+			got, err := f.Find()
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, rel(got))
+		})
+	}
+
+	_, err := New(root, WithPatterns("[a-"))
+	assert.EqualError(t, err, `invalid pattern "[a-", syntax error in pattern`)
+}
+
+func TestFindSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links require privileges on windows")
+	}
+	root, outside := t.TempDir(), t.TempDir()
+	tree(t, root, "app/.drone.yml")
+	tree(t, outside, "shared/.drone.yml")
+	for link, target := range map[string]string{
+		// a loop back to the root
+		"app/loop": root,
+		// the same directory outside the root linked twice
+		"shared":       filepath.Join(outside, "shared"),
+		"app/shared":   filepath.Join(outside, "shared"),
+		"linked.drone": filepath.Join(root, "app", ".drone.yml"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(link))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := New(root, WithPatterns("*.drone.yml", "*.drone"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	got, err := f.Find()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(root, "app", ".drone.yml"),
+		filepath.Join(root, "linked.drone"),
+	}, got)
+
+	f, err = New(root, WithPatterns("*.drone.yml"), WithFollowSymlinks(true))
+	if !assert.NoError(t, err) {
+		return
+	}
+	got, err = f.Find()
+	assert.NoError(t, err)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, filepath.Join(root, "app", ".drone.yml"), got[0])
+		// the directory linked twice is found through the lexically first link
+		assert.Equal(t, filepath.Join(root, "app", "shared", ".drone.yml"), got[1])
+	}
+}
+
+func TestPathLess(t *testing.T) {
+	assert.True(t, pathLess("/tmp/app/shared", "/tmp/shared"))
+	assert.False(t, pathLess("/tmp/shared", "/tmp/app/shared"))
+	// the paths are compared element by element rather than as strings
+	assert.True(t, pathLess("/tmp/a/shared", "/tmp/a-b/shared"))
+	assert.True(t, pathLess("/tmp/app", "/tmp/app/loop"))
+	assert.False(t, pathLess("/tmp/app", "/tmp/app"))
+}
+
+func TestReadConfig(t *testing.T) {
+	root := t.TempDir()
+	c, err := ReadConfig(root, "")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	tree(t, root, ".drone.yml", "ci/build.yml", "a/b/.drone.yml")
+	config := "patterns:\n- .drone.yml\n- ci/*.yml\nmaxDepth: 1\n"
+	if err := os.WriteFile(filepath.Join(root, ConfigFile), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = ReadConfig(root, "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	f, err := New(root, c.Options()...)
+	if !assert.NoError(t, err) {
+		return
+	}
+	got, err := f.Find()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, ".drone.yml"), filepath.Join(root, "ci", "build.yml")}, got)
+
+	_, err = ReadConfig(root, filepath.Join(root, "missing.yml"))
+	assert.Error(t, err)
+}
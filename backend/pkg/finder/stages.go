@@ -0,0 +1,234 @@
+package finder
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/converter"
+	"gopkg.in/yaml.v3"
+)
+
+// Stage is a docker pipeline of a pipeline file
+type Stage struct {
+	PipelineFile string     `json:"pipelineFile"`
+	PipelinePath string     `json:"pipelinePath"`
+	Kind         string     `json:"kind"`
+	Type         string     `json:"type"`
+	Name         string     `json:"name"`
+	DependsOn    []string   `json:"dependsOn,omitempty" yaml:"depends_on"`
+	Trigger      Conditions `json:"trigger,omitempty"`
+	Platform     *Platform  `json:"platform,omitempty"`
+	Steps        []Step     `json:"steps"`
+	Services     []Service  `json:"-"`
+}
+
+// Step is a step or a service of a Stage
+type Step struct {
+	Name      string     `json:"name"`
+	Image     string     `json:"image"`
+	Service   int        `json:"isService"`
+	DependsOn []string   `json:"dependsOn,omitempty" yaml:"depends_on"`
+	When      Conditions `json:"when,omitempty"`
+}
+
+// Platform is the platform a Stage runs on
+type Platform struct {
+	OS      string `json:"os,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// Conditions are the trigger conditions of a stage or the when conditions of a step keyed by their name
+type Conditions map[string]Condition
+
+// Condition is a trigger or when condition, written either as the values to include e.g. `event: push`
+// and `event: [push, tag]` or with the include and exclude keys
+type Condition struct {
+	Include stringList `json:"include,omitempty"`
+	Exclude stringList `json:"exclude,omitempty"`
+}
+
+func (c *Condition) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		type condition Condition
+		return value.Decode((*condition)(c))
+	}
+	return value.Decode(&c.Include)
+}
+
+// stringList is a list of strings that could be written as a single string
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var values []string
+	if err := value.Decode(&values); err != nil {
+		return err
+	}
+	*l = values
+	return nil
+}
+
+// Service is a service of a Stage, added to its steps
+type Service struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+type Stages []*Stage
+
+// FileError is a problem of a pipeline file that is left out of the stages, the document is the
+// index of the YAML document in the file starting at 1. The document and the line are set when
+// the problem could be located.
+type FileError struct {
+	File     string `json:"file"`
+	Document int    `json:"document,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+// yamlErrLine extracts the line from the YAML errors e.g. `yaml: line 3: mapping values are not allowed`
+var yamlErrLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// ReadStages reads the stages of the pipeline file, the documents that could not be read are
+// reported as errors and the other documents are still read
+func ReadStages(path string) (Stages, []FileError) {
+	stages, _, errs := ReadStagesWithImports(path)
+	return stages, errs
+}
+
+// ReadStagesWithImports is ReadStages that also returns the files imported by the Jsonnet and
+// Starlark pipeline files, the stages of which change along with the imported files
+func ReadStagesWithImports(path string) (Stages, []string, []FileError) {
+	var stages Stages
+	var errs []FileError
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, []FileError{{File: path, Message: err.Error()}}
+	}
+
+	// the Jsonnet and Starlark configs are rendered with the stub context, the lines of the
+	// rendered YAML are not reported as they are not the lines of the file
+	ext := filepath.Ext(path)
+	converted := ext == converter.ExtJsonnet || ext == converter.ExtStarlark
+	data, imports, err := converter.ConvertWithImports(path, data, nil)
+	if err != nil {
+		return nil, imports, []FileError{{File: path, Message: err.Error()}}
+	}
+
+	for i, doc := range splitDocuments(data) {
+		fail := func(err error) {
+			for _, msg := range yamlErrors(err) {
+				e := FileError{
+					File:     path,
+					Document: i + 1,
+					Message:  msg,
+				}
+				if m := yamlErrLine.FindStringSubmatch(msg); m != nil {
+					line, _ := strconv.Atoi(m[1])
+					e.Message = strings.TrimPrefix(msg, m[0])
+					if !converted {
+						e.Line = doc.line + line - 1
+					}
+				}
+				errs = append(errs, e)
+			}
+		}
+
+		node := new(yaml.Node)
+		if err := yaml.Unmarshal(doc.data, node); err != nil {
+			fail(err)
+			continue
+		}
+		stage := new(Stage)
+		stage.PipelineFile = path
+		stage.PipelinePath = filepath.Dir(path)
+		if err := node.Decode(stage); err != nil {
+			fail(err)
+			continue
+		}
+		// the secrets, signatures and the pipelines of the other runners are not stages of the extension
+		if !isDockerPipeline(stage) {
+			continue
+		}
+		for _, svc := range stage.Services {
+			stage.Steps = append(stage.Steps, Step{
+				Name:    svc.Name,
+				Image:   svc.Image,
+				Service: 1,
+			})
+		}
+		stages = append(stages, stage)
+	}
+	return stages, imports, errs
+}
+
+// isDockerPipeline reports whether the document is a pipeline of the docker runner, the default runner
+// when the type is not set
+func isDockerPipeline(stage *Stage) bool {
+	if stage.Kind != "pipeline" {
+		return false
+	}
+	if stage.Type == "" {
+		stage.Type = "docker"
+	}
+	return stage.Type == "docker"
+}
+
+// yamlErrors are the messages of the error, a type error has a message per value that could not be decoded
+func yamlErrors(err error) []string {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Errors
+	}
+	return []string{err.Error()}
+}
+
+// document is a YAML document of a file, the line is the line of the file the document starts at
+type document struct {
+	data []byte
+	line int
+}
+
+// splitDocuments splits the YAML data to its documents for each to be decoded on its own, a document
+// that could not be decoded does not stop the next ones. The documents with only comments are left out.
+func splitDocuments(data []byte) []document {
+	var docs []document
+	var current bytes.Buffer
+	start, blank := 1, true
+	flush := func() {
+		if !blank {
+			docs = append(docs, document{
+				data: append([]byte(nil), current.Bytes()...),
+				line: start,
+			})
+		}
+		current.Reset()
+		blank = true
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimRight(line, "\r\n")
+		if trimmed == "---" || strings.HasPrefix(trimmed, "--- ") {
+			flush()
+			start = i + 2
+			continue
+		}
+		if t := strings.TrimSpace(trimmed); t != "" && !strings.HasPrefix(t, "#") {
+			blank = false
+		}
+		current.WriteString(line)
+	}
+	flush()
+	return docs
+}
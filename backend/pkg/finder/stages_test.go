@@ -0,0 +1,137 @@
+package finder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const brokenPipeline = `# the first stage is fine
+kind: pipeline
+name: build
+steps:
+- name: test
+  image: golang
+---
+kind: pipeline
+name: deploy
+steps:
+  - name: push
+    image: plugins/docker
+   settings: {}
+---
+kind: pipeline
+name: lint
+steps: lint
+`
+
+func TestReadStages(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, ".drone.yml")
+	if err := os.WriteFile(file, []byte(brokenPipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stages, errs := ReadStages(file)
+	if assert.Len(t, stages, 1) {
+		assert.Equal(t, "build", stages[0].Name)
+		assert.Equal(t, []Step{{Name: "test", Image: "golang"}}, stages[0].Steps)
+	}
+	assert.Equal(t, []FileError{
+		{File: file, Document: 2, Line: 10, Message: "did not find expected '-' indicator"},
+		{File: file, Document: 3, Line: 17, Message: "cannot unmarshal !!str `lint` into []finder.Step"},
+	}, errs)
+
+	_, errs = ReadStages(filepath.Join(dir, "missing.yml"))
+	if assert.Len(t, errs, 1) {
+		assert.Contains(t, errs[0].Message, "no such file or directory")
+	}
+}
+
+const multiStagePipeline = `kind: pipeline
+type: docker
+name: build
+platform:
+  os: linux
+  arch: arm64
+steps:
+- name: test
+  image: golang
+- name: package
+  image: plugins/docker
+  depends_on: [test]
+  when:
+    event: tag
+    branch:
+      exclude: [feature/*]
+trigger:
+  branch:
+  - main
+  - release/*
+---
+kind: pipeline
+name: deploy
+depends_on:
+- build
+steps:
+- name: deploy
+  image: alpine
+---
+kind: pipeline
+type: kubernetes
+name: remote
+steps:
+- name: deploy
+  image: alpine
+---
+kind: secret
+name: token
+get:
+  path: secrets/ci
+  name: token
+`
+
+func TestReadStagesMetadata(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, ".drone.yml")
+	if err := os.WriteFile(file, []byte(multiStagePipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stages, errs := ReadStages(file)
+	assert.Empty(t, errs)
+	assert.Equal(t, Stages{
+		{
+			PipelineFile: file,
+			PipelinePath: dir,
+			Kind:         "pipeline",
+			Type:         "docker",
+			Name:         "build",
+			Trigger:      Conditions{"branch": {Include: stringList{"main", "release/*"}}},
+			Platform:     &Platform{OS: "linux", Arch: "arm64"},
+			Steps: []Step{
+				{Name: "test", Image: "golang"},
+				{
+					Name:      "package",
+					Image:     "plugins/docker",
+					DependsOn: []string{"test"},
+					When: Conditions{
+						"event":  {Include: stringList{"tag"}},
+						"branch": {Exclude: stringList{"feature/*"}},
+					},
+				},
+			},
+		},
+		{
+			PipelineFile: file,
+			PipelinePath: dir,
+			Kind:         "pipeline",
+			Type:         "docker",
+			Name:         "deploy",
+			DependsOn:    []string{"build"},
+			Steps:        []Step{{Name: "deploy", Image: "alpine"}},
+		},
+	}, stages)
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/harness/drone-ci-docker-extension/pkg/monitor"
+	"github.com/labstack/echo/v4"
+)
+
+// CancelStage cancels the running runs of the stage. The runs executing in the backend are
+// cancelled using their execution context, otherwise the step containers of the stage are killed and removed.
+// The steps that are yet to finish are marked as Stopped. A stage that has neither a running run nor
+// step containers is not running and is left as is.
+func (h *Handler) CancelStage(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	ctx := h.DatabaseConfig.Ctx
+	var stageID int
+	if err := echo.PathParamsBinder(c).
+		Int("id", &stageID).
+		BindError(); err != nil {
+		return err
+	}
+	log.Infof("Cancelling Stage %d", stageID)
+
+	stage, err := h.stageByID(ctx, stageID)
+	if err != nil {
+		return err
+	}
+
+	runs := make(db.Runs, 0)
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(&runs).
+		Where("stage_id = ? AND status = ?", stageID, db.Running).
+		Scan(ctx); err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		containers, err := h.stepContainers(ctx, stage)
+		if err != nil {
+			return err
+		}
+		if len(containers) == 0 {
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("stage %d is not running", stageID))
+		}
+	}
+
+	if err := h.cancel(ctx, stage, runs); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CancelRun cancels a running run of a stage, the same way as CancelStage
+func (h *Handler) CancelRun(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	ctx := h.DatabaseConfig.Ctx
+	var runID int
+	if err := echo.PathParamsBinder(c).
+		Int("id", &runID).
+		BindError(); err != nil {
+		return err
+	}
+	log.Infof("Cancelling Run %d", runID)
+
+	run := &db.Run{
+		ID: runID,
+	}
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(run).
+		WherePK().
+		Scan(ctx); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("run %d not found", runID))
+		}
+		return err
+	}
+	if run.Status != db.Running {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("run %d is not running", runID))
+	}
+
+	stage, err := h.stageByID(ctx, run.StageID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.cancel(ctx, stage, db.Runs{run}); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) stageByID(ctx context.Context, stageID int) (*db.Stage, error) {
+	stage := &db.Stage{
+		ID: stageID,
+	}
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(stage).
+		WherePK().
+		Scan(ctx); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("stage %d not found", stageID))
+		}
+		return nil, err
+	}
+	return stage, nil
+}
+
+// cancel cancels the runs of the stage and marks the steps that are yet to finish as Stopped
+func (h *Handler) cancel(ctx context.Context, stage *db.Stage, runs db.Runs) error {
+	cancelled := false
+	h.inflightMu.Lock()
+	for _, run := range runs {
+		if cancel, ok := h.inflight[run.ID]; ok {
+			cancel()
+			cancelled = true
+		}
+	}
+	h.inflightMu.Unlock()
+
+	// the run was not started by the backend e.g. drone exec from the CLI
+	if !cancelled {
+		if err := h.removeStepContainers(ctx, stage); err != nil {
+			return err
+		}
+	}
+
+	runIDs := make([]int, len(runs))
+	for i, run := range runs {
+		runIDs[i] = run.ID
+	}
+	steps, err := db.StopStage(ctx, h.DatabaseConfig.DB, stage.ID, runIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		h.Events.Publish(events.Event{
+			Type:     events.StepFinished,
+			StageID:  stage.ID,
+			StepID:   step.ID,
+			StepName: step.Name,
+			Status:   db.Stopped,
+		})
+	}
+	h.Events.Publish(events.Event{
+		Type:    events.StageStatusChanged,
+		StageID: stage.ID,
+		Status:  db.Stopped,
+	})
+
+	return nil
+}
+
+// stepContainers lists the step containers of the stage, none without a docker client
+func (h *Handler) stepContainers(ctx context.Context, stage *db.Stage) ([]types.Container, error) {
+	if h.DockerCli == nil {
+		h.DatabaseConfig.Log.Warnf("No docker client to list the containers of stage %s", stage.Name)
+		return nil, nil
+	}
+	return h.DockerCli.ContainerList(ctx, types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", fmt.Sprintf("%s=%s", monitor.LabelPipelineFile, stage.PipelineFile)),
+			filters.Arg("label", fmt.Sprintf("%s=%s", monitor.LabelStageName, stage.Name)),
+		),
+	})
+}
+
+// removeStepContainers kills and removes the step containers of the stage
+func (h *Handler) removeStepContainers(ctx context.Context, stage *db.Stage) error {
+	log := h.DatabaseConfig.Log
+	containers, err := h.stepContainers(ctx, stage)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		log.Debugf("Removing container %s of stage %s", container.ID, stage.Name)
+		//Force removal kills the container when it is running
+		if err := h.DockerCli.ContainerRemove(ctx, container.ID, types.ContainerRemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	droneapi "github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/drone"
+	echo "github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCancelStage(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	h := NewHandler(context.TODO(), getDBFile("test"), log)
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	// the pipeline files of the fixtures are not on disk
+	h.stat = statAny
+	h.runner = func(ctx context.Context, log *logrus.Logger, opts drone.Options) (*pipeline.State, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return &pipeline.State{
+			Stage: &droneapi.Stage{Status: droneapi.StatusKilled},
+		}, nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/stage/1/run", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetPath("/stage/:id/run")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	if err := h.RunStage(c); err != nil {
+		t.Fatal(err)
+	}
+	var run db.Run
+	if err := json.Unmarshal(rec.Body.Bytes(), &run); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	// the first step is done, the rest are yet to finish
+	if _, err := h.DatabaseConfig.DB.NewUpdate().
+		Model(&db.StageStep{ID: 1, Status: db.Success}).
+		Column("status").
+		WherePK().
+		Exec(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/stage/1/cancel", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetPath("/stage/:id/cancel")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	if assert.NoError(t, h.CancelStage(c)) {
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run was not cancelled")
+	}
+
+	stage := &db.Stage{ID: 1}
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(stage).
+		Relation("Steps").
+		WherePK().
+		Scan(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, db.Stopped, stage.Status)
+	for _, step := range stage.Steps {
+		want := db.Stopped
+		if step.ID == 1 {
+			want = db.Success
+		}
+		assert.Equal(t, want, step.Status, step.Name)
+	}
+
+	got := &db.Run{ID: run.ID}
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(got).
+		WherePK().
+		Scan(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, db.Stopped, got.Status)
+	assert.False(t, got.FinishedAt.IsZero())
+}
+
+func TestCancelIdleStage(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	h := NewHandler(context.TODO(), getDBFile("test"), log)
+	if _, err := h.DatabaseConfig.DB.NewUpdate().
+		Model(&db.Stage{ID: 1, Status: db.Success}).
+		Column("status").
+		WherePK().
+		Exec(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/stage/1/cancel", nil)
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.SetPath("/stage/:id/cancel")
+	c.SetParamNames("id")
+	c.SetParamValues("1")
+	var he *echo.HTTPError
+	if assert.ErrorAs(t, h.CancelStage(c), &he) {
+		assert.Equal(t, http.StatusConflict, he.Code)
+	}
+
+	// the stage and its steps are left as is
+	stage, err := h.stageByID(context.TODO(), 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, db.Success, stage.Status)
+	}
+	n, err := h.DatabaseConfig.DB.NewSelect().
+		Model((*db.StageStep)(nil)).
+		Where("stage_id = ? AND status = ?", 1, db.Stopped).
+		Count(context.TODO())
+	if assert.NoError(t, err) {
+		assert.Zero(t, n)
+	}
+}
+
+func TestCancelRun(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelTests := map[string]struct {
+		runID       string
+		wantErrCode int
+	}{
+		"notRunning": {
+			runID:       "2",
+			wantErrCode: http.StatusConflict,
+		},
+		"unknown": {
+			runID:       "100",
+			wantErrCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range cancelTests {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/run/"+tc.runID+"/cancel", nil)
+			rec := httptest.NewRecorder()
+			h := NewHandler(context.TODO(), getDBFile("test"), log)
+			c := e.NewContext(req, rec)
+			c.SetPath("/run/:id/cancel")
+			c.SetParamNames("id")
+			c.SetParamValues(tc.runID)
+
+			var he *echo.HTTPError
+			if assert.ErrorAs(t, h.CancelRun(c), &he) {
+				assert.Equal(t, tc.wantErrCode, he.Code)
+			}
+		})
+	}
+}
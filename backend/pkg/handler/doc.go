@@ -21,5 +21,14 @@ limitations under the License.
 // PATCH /stage/:id/:status - Update the status of the Stage
 // PATCH /step/:id/:status - Update the status of the Step
 // DELETE /stages - Delete the stages
-// GET /stage/:id/logs - Streaming API to the logs of a stage
+// GET /stage/:id/logs - Streaming API to the logs of a stage, supports query parameters step=<step id> and follow=true
+// GET /stage/:id/step/:stepId/logs - Paged logs of a step, supports query parameters offset, since and limit
+// GET /stage/:id/runs - fetches the run history of a stage, the latest run first
+// GET /run/:id - fetches a run of a stage along with its step runs
+// POST /stage/:id/run - runs the stage in the backend and returns the run right away, the body could carry include, exclude, secretFile, envFile, trusted, resumeAt and config
+// POST /stage/:id/step/:stepId/run - re-runs the stage from the step, the steps before it keep their statuses
+// POST /stage/:id/cancel - cancels the running stage and marks the steps yet to finish as stopped
+// POST /run/:id/cancel - cancels a running run of a stage
+// POST /lint - lints all the pipelines of a pipeline file and returns the problems with their file, pipeline, step, line and column
+// GET /events - Server-Sent Events of the stage and step status changes and log appends, supports query parameter stage=<stage id>
 package handler
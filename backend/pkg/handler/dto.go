@@ -1,11 +1,40 @@
 package handler
 
-import "github.com/harness/drone-ci-docker-extension/pkg/db"
+import (
+	"context"
+	"io/fs"
+	"sync"
+
+	"github.com/docker/docker/client"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/drone"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/harness/drone-ci-docker-extension/pkg/monitor"
+	"github.com/harness/drone-ci-docker-extension/pkg/watcher"
+	"github.com/sirupsen/logrus"
+)
 
 type Handler struct {
 	DatabaseConfig *db.Config
+	LogsPath       string
+	Events         *events.Broker
+	DockerCli      *client.Client
+	// Monitor reports the runs executed in the backend straight to the database when set
+	Monitor *monitor.Config
+	// Watcher keeps the stages in sync with their pipeline files when set
+	Watcher *watcher.Watcher
+	// runner executes the pipeline stage, defaults to drone.Run
+	runner func(ctx context.Context, log *logrus.Logger, opts drone.Options) (*pipeline.State, error)
+	// stat checks the files of the runs are accessible to the backend, defaults to os.Stat
+	stat func(name string) (fs.FileInfo, error)
+	// inflight holds the cancel functions of the runs executing in the backend, keyed by run id
+	inflight   map[int]context.CancelFunc
+	inflightMu sync.Mutex
 }
 
+type Option func(*Handler)
+
 //PipelineStep represents a pipeline step
 type PipelineStep struct {
 	StepName  string `json:"name"`
@@ -29,3 +58,50 @@ type DronePipeline struct {
 	Steps        []PipelineStep `json:"steps,omitempty"`
 	Status       PipelineStatus `json:"status"`
 }
+
+// StepLogs is a window of the logs of a step
+type StepLogs struct {
+	StageID  int    `json:"stageId"`
+	StepID   int    `json:"stepId"`
+	StepName string `json:"stepName"`
+	// Offset is the byte offset of the first line in the window
+	Offset int64 `json:"offset"`
+	// NextOffset is the byte offset to use to fetch the next window
+	NextOffset int64 `json:"nextOffset"`
+	// Line is the line number of the first line in the window, set only
+	// when the window is requested using line number
+	Line int `json:"line,omitempty"`
+	// NextLine is the line number to use to fetch the next window, set only
+	// when the window is requested using line number
+	NextLine int `json:"nextLine,omitempty"`
+	// Size is the current size of the log file in bytes
+	Size  int64    `json:"size"`
+	Lines []string `json:"lines"`
+	// EOF is set when the step is done and there are no more logs to fetch
+	EOF bool `json:"eof"`
+}
+
+// RunOptions is the request data to run a Stage
+type RunOptions struct {
+	Include    []string `json:"include,omitempty"`
+	Exclude    []string `json:"exclude,omitempty"`
+	SecretFile string   `json:"secretFile,omitempty"`
+	EnvFile    string   `json:"envFile,omitempty"`
+	Trusted    bool     `json:"trusted,omitempty"`
+	// ResumeAt is the name of the step to resume the stage at, the steps before it are not run
+	ResumeAt string `json:"resumeAt,omitempty"`
+	// Network is the docker network to attach the step containers to
+	Network string `json:"network,omitempty"`
+	// Config is the content of the pipeline file, required when the
+	// pipeline file is not accessible to the backend
+	Config string `json:"config,omitempty"`
+}
+
+// LintRequest is the pipeline file to lint
+type LintRequest struct {
+	PipelineFile string `json:"pipelineFile,omitempty"`
+	// Config is the content of the pipeline file, the pipeline file is read when not set
+	Config     string `json:"config,omitempty"`
+	SecretFile string `json:"secretFile,omitempty"`
+	Trusted    bool   `json:"trusted,omitempty"`
+}
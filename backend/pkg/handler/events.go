@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// eventsKeepAliveInterval is the interval to send a comment to keep the
+// events stream connection alive when there are no events
+const eventsKeepAliveInterval = 15 * time.Second
+
+// StreamEvents streams the stage and step events as Server-Sent Events. The events could
+// be filtered by the stage using the query parameter stage=<stage id>.
+func (h *Handler) StreamEvents(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	var stageID int
+	if err := echo.QueryParamsBinder(c).
+		Int("stage", &stageID).
+		BindError(); err != nil {
+		return err
+	}
+	log.Infof("Subscribing to events of stage %d", stageID)
+
+	eventsCh, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ticker := time.NewTicker(eventsKeepAliveInterval)
+	defer ticker.Stop()
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return err
+			}
+			res.Flush()
+		case e, ok := <-eventsCh:
+			if !ok {
+				return nil
+			}
+			if stageID != 0 && e.StageID != stageID {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", e.Type, b); err != nil {
+				return err
+			}
+			res.Flush()
+		}
+	}
+}
@@ -3,19 +3,45 @@ package handler
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"net/http"
 	"os"
 
 	"github.com/docker/docker/client"
 	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/drone"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
 	"github.com/harness/drone-ci-docker-extension/pkg/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
 )
 
-func NewHandler(ctx context.Context, dbFile string, log *logrus.Logger) *Handler {
+// WithLogsPath sets the directory where the pipeline step logs are saved
+func WithLogsPath(logsPath string) Option {
+	return func(h *Handler) {
+		if logsPath == "" {
+			logsPath = "/data/logs"
+		}
+		h.LogsPath = logsPath
+	}
+}
+
+// WithEvents sets the broker to publish the stage and step events
+func WithEvents(broker *events.Broker) Option {
+	return func(h *Handler) {
+		h.Events = broker
+	}
+}
+
+// WithDockerClient sets the docker client used to stop the step containers
+// of the runs that are not executed by the backend
+func WithDockerClient(cli *client.Client) Option {
+	return func(h *Handler) {
+		h.DockerCli = cli
+	}
+}
+
+func NewHandler(ctx context.Context, dbFile string, log *logrus.Logger, options ...Option) *Handler {
 	dbc := db.New(
 		db.WithContext(ctx),
 		db.WithLogger(log),
@@ -23,9 +49,23 @@ func NewHandler(ctx context.Context, dbFile string, log *logrus.Logger) *Handler
 	)
 	dbc.Init()
 
-	return &Handler{
+	h := &Handler{
 		DatabaseConfig: dbc,
+		LogsPath:       "/data/logs",
+		runner:         drone.Run,
+		stat:           os.Stat,
+		inflight:       make(map[int]context.CancelFunc),
+	}
+
+	for _, o := range options {
+		o(h)
 	}
+
+	if h.Events == nil {
+		h.Events = events.NewBroker(ctx, log)
+	}
+
+	return h
 }
 
 //GetStages selects all the available stages from the backend. The selected stages are sorted in ascending using column `pipeline_file`
@@ -115,6 +155,22 @@ func (h *Handler) DeleteAllStages(c echo.Context) error {
 	dbConn := h.DatabaseConfig.DB
 	err := dbConn.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
 		_, err := dbConn.NewTruncateTable().
+			Model((*db.StepRun)(nil)).
+			ContinueIdentity().
+			Cascade().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = dbConn.NewTruncateTable().
+			Model((*db.Run)(nil)).
+			ContinueIdentity().
+			Cascade().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = dbConn.NewTruncateTable().
 			Model((*db.StageStep)(nil)).
 			ContinueIdentity().
 			Cascade().
@@ -138,7 +194,8 @@ func (h *Handler) DeleteAllStages(c echo.Context) error {
 		return err
 	}
 	//Clean the logs directory
-	os.RemoveAll("/data/logs")
+	os.RemoveAll(h.LogsPath)
+	h.syncWatcher()
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -195,7 +252,7 @@ func (h *Handler) DeleteStage(c echo.Context) error {
 func (h *Handler) delete(stages db.Stages) error {
 	ctx := h.DatabaseConfig.Ctx
 	dbConn := h.DatabaseConfig.DB
-	return dbConn.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+	if err := dbConn.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
 		for _, stage := range stages {
 			_, err := dbConn.NewDelete().
 				Model((*db.StageStep)(nil)).
@@ -204,7 +261,10 @@ func (h *Handler) delete(stages db.Stages) error {
 			if err != nil {
 				return err
 			}
-			os.RemoveAll(fmt.Sprintf("/data/logs/%d", stage.ID))
+			if err := deleteRuns(ctx, dbConn, stage.ID); err != nil {
+				return err
+			}
+			os.RemoveAll(utils.StageLogsPath(h.LogsPath, stage.ID))
 		}
 
 		_, err := dbConn.NewDelete().
@@ -215,7 +275,21 @@ func (h *Handler) delete(stages db.Stages) error {
 			return err
 		}
 		return nil
-	})
+	}); err != nil {
+		return err
+	}
+	h.syncWatcher()
+	return nil
+}
+
+// syncWatcher updates the pipeline files watched for changes after the stages are saved or deleted
+func (h *Handler) syncWatcher() {
+	if h.Watcher == nil {
+		return
+	}
+	if err := h.Watcher.Sync(); err != nil {
+		h.DatabaseConfig.Log.Errorf("Error watching the pipeline files, %v", err)
+	}
 }
 
 //SaveStages saves one or more stage ids to the backend
@@ -237,6 +311,11 @@ func (h *Handler) SaveStages(c echo.Context) error {
 			Set("pipeline_file = excluded.pipeline_file").
 			Set("pipeline_path = excluded.pipeline_path").
 			Set("status = excluded.status").
+			Set("kind = excluded.kind").
+			Set("type = excluded.type").
+			Set("depends_on = excluded.depends_on").
+			Set("? = excluded.?", bun.Ident("trigger"), bun.Ident("trigger")).
+			Set("platform = excluded.platform").
 			Exec(ctx)
 		if err != nil {
 			return err
@@ -253,6 +332,8 @@ func (h *Handler) SaveStages(c echo.Context) error {
 				Set("name = excluded.name").
 				Set("status = excluded.status").
 				Set("image = excluded.image").
+				Set("depends_on = excluded.depends_on").
+				Set("? = excluded.?", bun.Ident("when"), bun.Ident("when")).
 				Exec(ctx)
 			if err != nil {
 				return err
@@ -262,25 +343,11 @@ func (h *Handler) SaveStages(c echo.Context) error {
 	}); err != nil {
 		return err
 	}
+	h.syncWatcher()
 
 	return c.JSON(http.StatusCreated, stages)
 }
 
-// StageLogs retrieves logs associated with the stage. It is streaming operation
-// that continuously reads from file system file
-func (h *Handler) StageLogs(c echo.Context) error {
-	log := h.DatabaseConfig.Log
-	var stageID int
-	if err := echo.PathParamsBinder(c).
-		Int("id", &stageID).
-		BindError(); err != nil {
-		return err
-	}
-	log.Infof("Getting logs for Stage %d", stageID)
-
-	return nil
-}
-
 // UpdateStageStatus is used to update the stage status. Stage status could be
 // one of the following:
 // 0  - None
@@ -315,11 +382,11 @@ func (h *Handler) UpdateStageStatus(c echo.Context) error {
 		return err
 	}
 
-	if cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation()); err != nil {
-		return err
-	} else {
-		utils.TriggerUIRefresh(ctx, cli, log)
-	}
+	h.Events.Publish(events.Event{
+		Type:    events.StageStatusChanged,
+		StageID: stageID,
+		Status:  db.Status(status),
+	})
 
 	return c.NoContent(http.StatusNoContent)
 }
@@ -336,7 +403,7 @@ func (h *Handler) UpdateStepStatus(c echo.Context) error {
 	dbConn := h.DatabaseConfig.DB
 	var stepID, status int
 	if err := echo.PathParamsBinder(c).
-		Int("stepId", &stepID).
+		Int("id", &stepID).
 		Int("status", &status).
 		BindError(); err != nil {
 		return err
@@ -359,11 +426,26 @@ func (h *Handler) UpdateStepStatus(c echo.Context) error {
 		return err
 	}
 
-	if cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation()); err != nil {
+	stageStep := &db.StageStep{ID: stepID}
+	if err := dbConn.NewSelect().
+		Model(stageStep).
+		WherePK().
+		Scan(ctx); err != nil {
 		return err
-	} else {
-		utils.TriggerUIRefresh(ctx, cli, log)
 	}
+
+	eventType := events.StepFinished
+	if stageStep.Status == db.Running {
+		eventType = events.StepStarted
+	}
+	h.Events.Publish(events.Event{
+		Type:     eventType,
+		StageID:  stageStep.StageID,
+		StepID:   stageStep.ID,
+		StepName: stageStep.Name,
+		Status:   stageStep.Status,
+	})
+
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -12,10 +12,12 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
 	"github.com/harness/drone-ci-docker-extension/pkg/utils"
 	echo "github.com/labstack/echo/v4"
 	"github.com/sirupsen/logrus"
@@ -48,7 +50,7 @@ func loadFixtures() error {
 
 	err = dbc.DB.Ping()
 
-	dbfx := dbfixture.New(dbc.DB, dbfixture.WithRecreateTables())
+	dbfx := dbfixture.New(dbc.DB, dbfixture.WithTruncateTables())
 	if err := dbfx.Load(dbc.Ctx, os.DirFS("."), "testdata/fixtures.yaml"); err != nil {
 		return err
 	}
@@ -159,15 +161,23 @@ func TestGetStage(t *testing.T) {
 				Name:         "default",
 				PipelineFile: "/tmp/examples/use-env/.drone.yml",
 				PipelinePath: "/tmp/examples/use-env",
+				Kind:         "pipeline",
+				Type:         "docker",
 				Status:       0,
 				Logs:         nil,
+				StartedAt:    time.Date(2022, 10, 1, 11, 0, 0, 0, time.UTC),
+				FinishedAt:   time.Date(2022, 10, 1, 11, 0, 3, 0, time.UTC),
+				Duration:     3000,
 				Steps: []*db.StageStep{
 					{
-						ID:      11,
-						Name:    "display environment variables",
-						Image:   "busybox",
-						StageID: 6,
-						Status:  0,
+						ID:         11,
+						Name:       "display environment variables",
+						Image:      "busybox",
+						StageID:    6,
+						Status:     0,
+						StartedAt:  time.Date(2022, 10, 1, 11, 0, 0, 0, time.UTC),
+						FinishedAt: time.Date(2022, 10, 1, 11, 0, 2, 500000000, time.UTC),
+						Duration:   2500,
 					},
 				},
 			},
@@ -373,6 +383,8 @@ func TestSaveStage(t *testing.T) {
 					  "status": 1
 					}
 				  ],
+				  "kind": "pipeline",
+				  "type": "docker",
 				  "logs": "",
 				  "status": 1
 				}
@@ -416,9 +428,16 @@ func TestSaveStage(t *testing.T) {
 					{
 						"name": "package",
 						"image": "plugins/docker",
-						"status": 1
+						"status": 1,
+						"dependsOn": ["build", "test"],
+						"when": {"event": {"include": ["tag"]}}
 					  }
 				  ],
+				  "kind": "pipeline",
+				  "type": "docker",
+				  "dependsOn": ["lint"],
+				  "trigger": {"branch": {"include": ["main"], "exclude": ["feature/*"]}},
+				  "platform": {"os": "linux", "arch": "arm64"},
 				  "logs": "",
 				  "status": 1
 				}
@@ -441,11 +460,13 @@ func TestSaveStage(t *testing.T) {
 					StageID: 5,
 				},
 				{
-					ID:      3,
-					Name:    "package",
-					Image:   "plugins/docker",
-					Status:  db.Success,
-					StageID: 5,
+					ID:        3,
+					Name:      "package",
+					Image:     "plugins/docker",
+					Status:    db.Success,
+					StageID:   5,
+					DependsOn: []string{"build", "test"},
+					When:      db.Conditions{"event": {Include: []string{"tag"}}},
 				},
 			},
 		},
@@ -535,8 +556,14 @@ func TestUpdateStageStatus(t *testing.T) {
 			c.SetPath(tc.uriPath)
 			c.SetParamNames("id", "status")
 			c.SetParamValues(fmt.Sprintf("%d", tc.stageID), fmt.Sprintf("%d", tc.want))
+			eventsCh, unsubscribe := h.Events.Subscribe()
+			defer unsubscribe()
 			if assert.NoError(t, h.UpdateStageStatus(c)) {
 				assert.Equal(t, http.StatusNoContent, rec.Code)
+				e := <-eventsCh
+				assert.Equal(t, events.StageStatusChanged, e.Type)
+				assert.Equal(t, tc.stageID, e.StageID)
+				assert.Equal(t, tc.want, e.Status)
 				dbConn := h.DatabaseConfig.DB
 				stage := &db.Stage{ID: tc.stageID}
 				err := dbConn.NewSelect().
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/drone"
+	"github.com/labstack/echo/v4"
+)
+
+// Lint lints all the pipelines of the pipeline file and responds with the diagnostics,
+// an empty list when the pipelines have no problems
+func (h *Handler) Lint(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	var req LintRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	log.Infof("Linting pipeline file %s", req.PipelineFile)
+
+	data := []byte(req.Config)
+	if req.Config == "" {
+		if req.PipelineFile == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "pipelineFile or config is required")
+		}
+		var err error
+		if data, err = os.ReadFile(req.PipelineFile); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("pipeline file %s not found", req.PipelineFile))
+			}
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, drone.Lint(req.PipelineFile, data, drone.LintOptions{
+		Trusted: req.Trusted,
+		Secrets: drone.SecretOptions{File: req.SecretFile},
+	}))
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/drone"
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+
+	lintTests := map[string]struct {
+		body        string
+		want        []drone.Diagnostic
+		wantErrCode int
+	}{
+		"config": {
+			body: `{"pipelineFile":"/tmp/examples/lint/.drone.yml","config":"kind: pipeline\nname: default\nsteps:\n- name: build\n"}`,
+			want: []drone.Diagnostic{
+				{File: "/tmp/examples/lint/.drone.yml", Pipeline: "default", Step: "build", Line: 4, Column: 3, Message: "step build has no image"},
+			},
+		},
+		"noProblems": {
+			body: `{"config":"kind: pipeline\nname: default\nsteps:\n- name: build\n  image: golang\n"}`,
+			want: []drone.Diagnostic{},
+		},
+		"pipelineFileNotFound": {
+			body:        `{"pipelineFile":"/tmp/examples/not-found/.drone.yml"}`,
+			wantErrCode: http.StatusNotFound,
+		},
+		"noPipeline": {
+			body:        `{}`,
+			wantErrCode: http.StatusBadRequest,
+		},
+	}
+
+	for name, tc := range lintTests {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/lint", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			h := NewHandler(context.TODO(), getDBFile("test"), log)
+			c := e.NewContext(req, rec)
+
+			err := h.Lint(c)
+			if tc.wantErrCode != 0 {
+				var he *echo.HTTPError
+				if assert.ErrorAs(t, err, &he) {
+					assert.Equal(t, tc.wantErrCode, he.Code)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, http.StatusOK, rec.Code)
+				var got []drone.Diagnostic
+				if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// logsPollInterval is the interval to check for new log content and
+// status changes while following the logs
+const logsPollInterval = 500 * time.Millisecond
+
+// logStream writes the log content to the client either as plain chunked
+// response or as Server-Sent Events
+type logStream struct {
+	res *echo.Response
+	sse bool
+	// partial holds the incomplete line that is yet to be sent as event
+	partial []byte
+}
+
+func newLogStream(c echo.Context) *logStream {
+	sse := strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "text/event-stream")
+	res := c.Response()
+	if sse {
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Header().Set(echo.HeaderConnection, "keep-alive")
+	} else {
+		res.Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	}
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+	return &logStream{
+		res: res,
+		sse: sse,
+	}
+}
+
+// step marks the start of the logs of the step, sent only as event
+// as the plain stream is the combined logs of the steps
+func (s *logStream) step(step *db.StageStep) error {
+	if !s.sse {
+		return nil
+	}
+	if err := s.flushPartial(); err != nil {
+		return err
+	}
+	return s.event("step", step.Name)
+}
+
+// Write implements io.Writer
+func (s *logStream) Write(b []byte) (int, error) {
+	if !s.sse {
+		n, err := s.res.Write(b)
+		s.res.Flush()
+		return n, err
+	}
+
+	data := append(s.partial, b...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		if err := s.event("log", string(data[:i])); err != nil {
+			return 0, err
+		}
+		data = data[i+1:]
+	}
+	s.partial = append([]byte(nil), data...)
+	return len(b), nil
+}
+
+// end ends the stream
+func (s *logStream) end() error {
+	if !s.sse {
+		return nil
+	}
+	if err := s.flushPartial(); err != nil {
+		return err
+	}
+	return s.event("end", "")
+}
+
+func (s *logStream) flushPartial() error {
+	if len(s.partial) == 0 {
+		return nil
+	}
+	line := string(s.partial)
+	s.partial = nil
+	return s.event("log", line)
+}
+
+func (s *logStream) event(name, data string) error {
+	if _, err := fmt.Fprintf(s.res, "event: %s\ndata: %s\n\n", name, data); err != nil {
+		return err
+	}
+	s.res.Flush()
+	return nil
+}
+
+// StageLogs retrieves logs associated with the stage. It is streaming operation
+// that continuously reads from file system file. The following query parameters are supported:
+// step - the id of the step to stream the logs, if not set the logs of all the steps of the stage are combined
+// follow - keep streaming the logs as they grow until the step(s) are done running
+// The logs are sent as Server-Sent Events when the request accepts "text/event-stream" or as a chunked plain text response otherwise.
+func (h *Handler) StageLogs(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	var stageID, stepID int
+	var follow bool
+	if err := echo.PathParamsBinder(c).
+		Int("id", &stageID).
+		BindError(); err != nil {
+		return err
+	}
+	if err := echo.QueryParamsBinder(c).
+		Int("step", &stepID).
+		Bool("follow", &follow).
+		BindError(); err != nil {
+		return err
+	}
+	log.Infof("Getting logs for Stage %d", stageID)
+
+	stage := &db.Stage{
+		ID: stageID,
+	}
+	err := h.DatabaseConfig.DB.NewSelect().
+		Model(stage).
+		Relation("Steps").
+		WherePK().
+		Scan(h.DatabaseConfig.Ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("stage %d not found", stageID))
+		}
+		return err
+	}
+
+	steps := stage.Steps
+	if stepID != 0 {
+		steps = nil
+		for _, st := range stage.Steps {
+			if st.ID == stepID {
+				steps = db.Steps{st}
+				break
+			}
+		}
+		if len(steps) == 0 {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("step %d not found in stage %d", stepID, stageID))
+		}
+	}
+
+	ctx := c.Request().Context()
+	stream := newLogStream(c)
+	stageLogsPath := utils.StageLogsPath(h.LogsPath, stageID)
+	for _, step := range steps {
+		if err := stream.step(step); err != nil {
+			return err
+		}
+		if err := h.tailStepLogs(ctx, stream, stageLogsPath, step, follow); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+	}
+
+	return stream.end()
+}
+
+// tailStepLogs copies the logs of the step to the writer w. When follow is enabled
+// the log file is tailed until the step is done running.
+func (h *Handler) tailStepLogs(ctx context.Context, w io.Writer, stageLogsPath string, step *db.StageStep, follow bool) error {
+	logFile := utils.StepLogFile(stageLogsPath, step.Name)
+	var offset int64
+	for {
+		running := false
+		if follow {
+			var err error
+			if running, err = h.isStepActive(ctx, step); err != nil {
+				return err
+			}
+		}
+
+		n, err := copyLogsFrom(w, logFile, offset)
+		if err != nil {
+			return err
+		}
+		offset += n
+
+		if !running {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(logsPollInterval):
+		}
+	}
+}
+
+// isStepActive checks if the step is running or is yet to run as part of the
+// running stage
+func (h *Handler) isStepActive(ctx context.Context, step *db.StageStep) (bool, error) {
+	dbConn := h.DatabaseConfig.DB
+	if err := dbConn.NewSelect().
+		Model(step).
+		Column("status").
+		WherePK().
+		Scan(ctx); err != nil {
+		return false, err
+	}
+	switch step.Status {
+	case db.Running:
+		return true, nil
+	case db.None:
+		return dbConn.NewSelect().
+			Model((*db.Stage)(nil)).
+			Where("id = ? AND status = ?", step.StageID, db.Running).
+			Exists(ctx)
+	}
+	return false, nil
+}
+
+// copyLogsFrom copies the content of the log file from the offset to the
+// writer w. A missing log file is treated as empty.
+func copyLogsFrom(w io.Writer, logFile string, offset int64) (int64, error) {
+	f, err := os.Open(logFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	return io.Copy(w, f)
+}
+
+// StepLogs retrieves a window of the logs of a step. The following query parameters are supported:
+// offset - the byte offset to start reading the logs from
+// since - the line number to start reading the logs from, used only when offset is not set
+// limit - the maximum number of lines to return, defaults to 500
+// The response carries the offset and line number to use for fetching the next window.
+func (h *Handler) StepLogs(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	ctx := h.DatabaseConfig.Ctx
+	var stageID, stepID, since int
+	var offset int64
+	limit := defaultLogsLimit
+	if err := echo.PathParamsBinder(c).
+		Int("id", &stageID).
+		Int("stepId", &stepID).
+		BindError(); err != nil {
+		return err
+	}
+	if err := echo.QueryParamsBinder(c).
+		Int64("offset", &offset).
+		Int("since", &since).
+		Int("limit", &limit).
+		BindError(); err != nil {
+		return err
+	}
+	if offset < 0 || since < 0 || limit <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "offset, since and limit must be positive numbers")
+	}
+	log.Infof("Getting logs for Step %d of Stage %d", stepID, stageID)
+
+	step := &db.StageStep{}
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(step).
+		Where("id = ? AND stage_id = ?", stepID, stageID).
+		Scan(ctx); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("step %d not found in stage %d", stepID, stageID))
+		}
+		return err
+	}
+
+	active, err := h.isStepActive(ctx, step)
+	if err != nil {
+		return err
+	}
+
+	logFile := utils.StepLogFile(utils.StageLogsPath(h.LogsPath, stageID), step.Name)
+	stepLogs, err := readLogsWindow(logFile, offset, since, limit, !active)
+	if err != nil {
+		return err
+	}
+	stepLogs.StageID = stageID
+	stepLogs.StepID = stepID
+	stepLogs.StepName = step.Name
+	stepLogs.EOF = !active && stepLogs.NextOffset >= stepLogs.Size
+	// line numbers are not known when reading by offset
+	if offset > 0 {
+		stepLogs.Line = 0
+		stepLogs.NextLine = 0
+	}
+
+	return c.JSON(http.StatusOK, stepLogs)
+}
+
+// defaultLogsLimit is the default number of log lines returned by StepLogs
+const defaultLogsLimit = 500
+
+// readLogsWindow reads at most limit lines from the logFile starting at the byte offset,
+// or at the line since when offset is not set. The last line without new line is considered only
+// if it is final i.e. no more logs will be written to the file.
+func readLogsWindow(logFile string, offset int64, since, limit int, final bool) (*StepLogs, error) {
+	stepLogs := &StepLogs{
+		Offset: offset,
+		Lines:  []string{},
+	}
+	f, err := os.Open(logFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			stepLogs.NextOffset = offset
+			stepLogs.Line = since
+			stepLogs.NextLine = since
+			return stepLogs, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	stepLogs.Size = fi.Size()
+	if offset > stepLogs.Size {
+		return nil, echo.NewHTTPError(http.StatusRequestedRangeNotSatisfiable, fmt.Sprintf("offset %d is beyond the log size %d", offset, stepLogs.Size))
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	pos := offset
+	line := 0
+	// skip to the line since when not reading by offset
+	for offset == 0 && line < since {
+		b, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(b) == 0 || b[len(b)-1] != '\n' {
+			break
+		}
+		pos += int64(len(b))
+		line++
+	}
+	stepLogs.Offset = pos
+	stepLogs.Line = line
+
+	for len(stepLogs.Lines) < limit {
+		b, err := r.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		complete := len(b) > 0 && b[len(b)-1] == '\n'
+		if len(b) == 0 || (!complete && !final) {
+			break
+		}
+		pos += int64(len(b))
+		line++
+		stepLogs.Lines = append(stepLogs.Lines, strings.TrimSuffix(string(b), "\n"))
+		if !complete {
+			break
+		}
+	}
+	stepLogs.NextOffset = pos
+	stepLogs.NextLine = line
+
+	return stepLogs, nil
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	echo "github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageLogs(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+
+	logsPath := t.TempDir()
+	stageLogsPath := utils.StageLogsPath(logsPath, 6)
+	if err := os.MkdirAll(stageLogsPath, 0744); err != nil {
+		t.Fatal(err)
+	}
+	stepLogs := "HOME=/root\nDRONE=true"
+	if err := os.WriteFile(utils.StepLogFile(stageLogsPath, "display environment variables"), []byte(stepLogs), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	logsTests := map[string]struct {
+		stepID      int
+		accept      string
+		wantCode    int
+		wantBody    string
+		wantErrCode int
+	}{
+		"combined": {
+			wantCode: http.StatusOK,
+			wantBody: stepLogs,
+		},
+		"step": {
+			stepID:   11,
+			wantCode: http.StatusOK,
+			wantBody: stepLogs,
+		},
+		"sse": {
+			stepID:   11,
+			accept:   "text/event-stream",
+			wantCode: http.StatusOK,
+			wantBody: "event: step\ndata: display environment variables\n\n" +
+				"event: log\ndata: HOME=/root\n\n" +
+				"event: log\ndata: DRONE=true\n\n" +
+				"event: end\ndata: \n\n",
+		},
+		"unknownStep": {
+			stepID:      1,
+			wantErrCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range logsTests {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			uri := "/stage/6/logs"
+			if tc.stepID != 0 {
+				uri = fmt.Sprintf("%s?step=%d", uri, tc.stepID)
+			}
+			req := httptest.NewRequest(http.MethodGet, uri, nil)
+			if tc.accept != "" {
+				req.Header.Set(echo.HeaderAccept, tc.accept)
+			}
+			rec := httptest.NewRecorder()
+			h := NewHandler(context.TODO(), getDBFile("test"), log, WithLogsPath(logsPath))
+			c := e.NewContext(req, rec)
+			c.SetPath("/stage/:id/logs")
+			c.SetParamNames("id")
+			c.SetParamValues("6")
+
+			err := h.StageLogs(c)
+			if tc.wantErrCode != 0 {
+				var he *echo.HTTPError
+				if assert.ErrorAs(t, err, &he) {
+					assert.Equal(t, tc.wantErrCode, he.Code)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.wantCode, rec.Code)
+				assert.Equal(t, tc.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCopyLogsFrom(t *testing.T) {
+	logFile := path.Join(t.TempDir(), "step.log")
+	if err := os.WriteFile(logFile, []byte("one\ntwo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	n, err := copyLogsFrom(rec, logFile, 4)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(4), n)
+		assert.Equal(t, "two\n", rec.Body.String())
+	}
+
+	n, err = copyLogsFrom(rec, path.Join(t.TempDir(), "missing.log"), 0)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(0), n)
+	}
+}
+
+func TestReadLogsWindow(t *testing.T) {
+	logFile := path.Join(t.TempDir(), "step.log")
+	if err := os.WriteFile(logFile, []byte("one\ntwo\nthree\nfour"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	windowTests := map[string]struct {
+		offset int64
+		since  int
+		limit  int
+		final  bool
+		want   StepLogs
+	}{
+		"firstPage": {
+			limit: 2,
+			want: StepLogs{
+				Offset:     0,
+				NextOffset: 8,
+				Line:       0,
+				NextLine:   2,
+				Size:       18,
+				Lines:      []string{"one", "two"},
+			},
+		},
+		"byOffset": {
+			offset: 8,
+			limit:  2,
+			want: StepLogs{
+				Offset:     8,
+				NextOffset: 14,
+				Line:       0,
+				NextLine:   1,
+				Size:       18,
+				Lines:      []string{"three"},
+			},
+		},
+		"bySinceFinal": {
+			since: 2,
+			limit: 10,
+			final: true,
+			want: StepLogs{
+				Offset:     8,
+				NextOffset: 18,
+				Line:       2,
+				NextLine:   4,
+				Size:       18,
+				Lines:      []string{"three", "four"},
+			},
+		},
+	}
+
+	for name, tc := range windowTests {
+		t.Run(name, func(t *testing.T) {
+			got, err := readLogsWindow(logFile, tc.offset, tc.since, tc.limit, tc.final)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.want, *got)
+			}
+		})
+	}
+}
+
+func TestStepLogs(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+
+	logsPath := t.TempDir()
+	stageLogsPath := utils.StageLogsPath(logsPath, 6)
+	if err := os.MkdirAll(stageLogsPath, 0744); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(utils.StepLogFile(stageLogsPath, "display environment variables"), []byte("HOME=/root\nDRONE=true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/stage/6/step/11/logs?limit=1", nil)
+	rec := httptest.NewRecorder()
+	h := NewHandler(context.TODO(), getDBFile("test"), log, WithLogsPath(logsPath))
+	c := e.NewContext(req, rec)
+	c.SetPath("/stage/:id/step/:stepId/logs")
+	c.SetParamNames("id", "stepId")
+	c.SetParamValues("6", "11")
+
+	if assert.NoError(t, h.StepLogs(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var got StepLogs
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, StepLogs{
+			StageID:    6,
+			StepID:     11,
+			StepName:   "display environment variables",
+			NextOffset: 11,
+			NextLine:   1,
+			Size:       22,
+			Lines:      []string{"HOME=/root"},
+		}, got)
+	}
+}
@@ -0,0 +1,373 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	droneapi "github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/drone"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// GetStageRuns selects the runs of a stage along with its step runs, the latest run first.
+// The number of runs could be limited using the query parameter limit.
+func (h *Handler) GetStageRuns(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	var stageID, limit int
+	if err := echo.PathParamsBinder(c).
+		Int("id", &stageID).
+		BindError(); err != nil {
+		return err
+	}
+	if err := echo.QueryParamsBinder(c).
+		Int("limit", &limit).
+		BindError(); err != nil {
+		return err
+	}
+	log.Infof("Get Runs of Stage %d", stageID)
+
+	runs := make(db.Runs, 0)
+	q := h.DatabaseConfig.DB.NewSelect().
+		Model(&runs).
+		Relation("Steps", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order("sr.id ASC")
+		}).
+		Where("stage_id = ?", stageID).
+		Order("r.id DESC")
+	if limit > 0 {
+		q = q.Limit(limit)
+	}
+
+	if err := q.Scan(h.DatabaseConfig.Ctx); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, runs)
+}
+
+// GetRun selects a run by id along with its step runs
+func (h *Handler) GetRun(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	var runID int
+	if err := echo.PathParamsBinder(c).
+		Int("id", &runID).
+		BindError(); err != nil {
+		return err
+	}
+	log.Infof("Get Run %d", runID)
+
+	run := &db.Run{
+		ID: runID,
+	}
+	err := h.DatabaseConfig.DB.NewSelect().
+		Model(run).
+		Relation("Steps", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order("sr.id ASC")
+		}).
+		WherePK().
+		Scan(h.DatabaseConfig.Ctx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("run %d not found", runID))
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, run)
+}
+
+// deleteRuns deletes the runs of the stage along with its step runs
+func deleteRuns(ctx context.Context, tx bun.IDB, stageID int) error {
+	_, err := tx.NewDelete().
+		Model((*db.StepRun)(nil)).
+		Where("run_id IN (?)", tx.NewSelect().
+			Model((*db.Run)(nil)).
+			Column("id").
+			Where("stage_id = ?", stageID)).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.NewDelete().
+		Model((*db.Run)(nil)).
+		Where("stage_id = ?", stageID).
+		Exec(ctx)
+	return err
+}
+
+// RunStage runs the stage in the backend. The run is recorded and its id returned right away,
+// the progress of the run could be tracked using the run, logs and events endpoints.
+// The request body is optional and carries the RunOptions. The files of the run that the backend
+// could not read are rejected with 422 Unprocessable Entity, to be run on the host instead.
+func (h *Handler) RunStage(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	ctx := h.DatabaseConfig.Ctx
+	var stageID int
+	if err := echo.PathParamsBinder(c).
+		Int("id", &stageID).
+		BindError(); err != nil {
+		return err
+	}
+	var opts RunOptions
+	if err := (&echo.DefaultBinder{}).BindBody(c, &opts); err != nil {
+		return err
+	}
+	log.Infof("Running Stage %d", stageID)
+
+	stage, err := h.stageByID(ctx, stageID)
+	if err != nil {
+		return err
+	}
+
+	return h.runStage(c, stage, opts)
+}
+
+// RunStep re-runs the stage from the step, the steps before it are not run and keep their statuses.
+// The request body is optional and carries the RunOptions, the same as RunStage.
+func (h *Handler) RunStep(c echo.Context) error {
+	log := h.DatabaseConfig.Log
+	ctx := h.DatabaseConfig.Ctx
+	var stageID, stepID int
+	if err := echo.PathParamsBinder(c).
+		Int("id", &stageID).
+		Int("stepId", &stepID).
+		BindError(); err != nil {
+		return err
+	}
+	var opts RunOptions
+	if err := (&echo.DefaultBinder{}).BindBody(c, &opts); err != nil {
+		return err
+	}
+	log.Infof("Running Stage %d from Step %d", stageID, stepID)
+
+	stage, err := h.stageByID(ctx, stageID)
+	if err != nil {
+		return err
+	}
+	step := new(db.StageStep)
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(step).
+		Where("id = ? AND stage_id = ?", stepID, stageID).
+		Scan(ctx); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("step %d of stage %d not found", stepID, stageID))
+		}
+		return err
+	}
+	opts.ResumeAt = step.Name
+
+	return h.runStage(c, stage, opts)
+}
+
+// runStage records a new run of the stage and executes it in the background
+func (h *Handler) runStage(c echo.Context, stage *db.Stage, opts RunOptions) error {
+	ctx := h.DatabaseConfig.Ctx
+	dbConn := h.DatabaseConfig.DB
+
+	if err := h.accessible(stage, opts); err != nil {
+		return err
+	}
+
+	run := &db.Run{
+		StageID:   stage.ID,
+		Key:       strconv.FormatInt(time.Now().UnixNano(), 10),
+		Status:    db.Running,
+		Includes:  opts.Include,
+		Excludes:  opts.Exclude,
+		StartedAt: time.Now(),
+	}
+	if err := dbConn.RunInTx(ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		// marking the stage as running is the guard against the concurrent runs of the stage
+		res, err := tx.NewUpdate().
+			Model((*db.Stage)(nil)).
+			Set("status = ?", db.Running).
+			Where("id = ? AND status <> ?", stage.ID, db.Running).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("stage %d is already running", stage.ID))
+		}
+		if _, err := tx.NewInsert().
+			Model(run).
+			Exec(ctx); err != nil {
+			return err
+		}
+		run.LogsPath = utils.RunLogsPath(utils.StageLogsPath(h.LogsPath, stage.ID), run.ID)
+		_, err = tx.NewUpdate().
+			Model(run).
+			Column("logs_path").
+			WherePK().
+			Exec(ctx)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	var config []byte
+	if opts.Config != "" {
+		config = []byte(opts.Config)
+	}
+	h.Events.Publish(events.Event{
+		Type:    events.StageStatusChanged,
+		StageID: stage.ID,
+		Status:  db.Running,
+	})
+
+	// the run is cancellable as soon as it is accepted
+	runCtx, cancel := context.WithCancel(ctx)
+	h.inflightMu.Lock()
+	h.inflight[run.ID] = cancel
+	h.inflightMu.Unlock()
+
+	// the run is updated by execute while the response is encoded
+	started := *run
+	go h.execute(runCtx, cancel, stage, &started, drone.Options{
+		PipelineFile: stage.PipelineFile,
+		Config:       config,
+		Stage:        stage.Name,
+		Include:      opts.Include,
+		Exclude:      opts.Exclude,
+		SecretFile:   opts.SecretFile,
+		EnvFile:      opts.EnvFile,
+		Trusted:      opts.Trusted,
+		ResumeAt:     opts.ResumeAt,
+		Network:      opts.Network,
+		RunKey:       run.Key,
+	})
+
+	return c.JSON(http.StatusAccepted, run)
+}
+
+// accessible checks the files of the run could be read by the backend, the files are on the host
+// and only the host directories shared with the backend are accessible e.g. not the ones of windows.
+// The runs of the files that are not accessible are to be executed on the host.
+func (h *Handler) accessible(stage *db.Stage, opts RunOptions) error {
+	files := []string{opts.SecretFile, opts.EnvFile}
+	// the pipeline file is not read when its content is sent along
+	if opts.Config == "" {
+		files = append(files, stage.PipelineFile)
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if _, err := h.stat(file); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("file %s is not accessible to the backend, %v", file, err))
+		}
+	}
+	return nil
+}
+
+// execute runs the stage and records the final status of the run, unless the
+// reporter or the monitor has already recorded it. The cancel of the run context
+// is released once the run is done, a run that panics is recorded as an Error.
+func (h *Handler) execute(runCtx context.Context, cancel context.CancelFunc, stage *db.Stage, run *db.Run, opts drone.Options) {
+	log := h.DatabaseConfig.Log
+	defer func() {
+		h.inflightMu.Lock()
+		delete(h.inflight, run.ID)
+		h.inflightMu.Unlock()
+		cancel()
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Run %d of stage %d panicked, %v\n%s", run.ID, run.StageID, r, debug.Stack())
+			h.finish(run, db.Error, fmt.Errorf("run panicked, %v", r))
+		}
+	}()
+
+	if h.Monitor != nil {
+		reporter, err := h.Monitor.NewReporter(stage, run)
+		if err != nil {
+			log.Warnf("Unable to report run %d of stage %d, the monitor records it instead, %v", run.ID, run.StageID, err)
+		} else {
+			defer reporter.Close()
+			opts.Reporter = reporter
+			opts.Streamer = reporter
+		}
+	}
+
+	state, runErr := h.runner(runCtx, log, opts)
+	status := runStatus(state, runErr)
+	if runErr != nil {
+		log.Errorf("Run %d of stage %d failed, %v", run.ID, run.StageID, runErr)
+	}
+	h.finish(run, status, runErr)
+}
+
+// finish records the final status of the run and of its stage, unless the reporter or the
+// monitor has already recorded it
+func (h *Handler) finish(run *db.Run, status db.Status, runErr error) {
+	log := h.DatabaseConfig.Log
+	ctx := h.DatabaseConfig.Ctx
+	run.Status = status
+	run.FinishedAt = time.Now()
+	res, err := h.DatabaseConfig.DB.NewUpdate().
+		Model(run).
+		Column("status", "finished_at", "modified_at").
+		WherePK().
+		Where("status = ?", db.Running).
+		Exec(ctx)
+	if err != nil {
+		log.Errorf("Unable to record the status of run %d, %v", run.ID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+	// the stage is still running when it did not start or nothing else recorded its status,
+	// otherwise it is left as recorded by the monitor
+	res, err = h.DatabaseConfig.DB.NewUpdate().
+		Model((*db.Stage)(nil)).
+		Set("status = ?", status).
+		Where("id = ? AND status = ?", run.StageID, db.Running).
+		Exec(ctx)
+	if err != nil {
+		log.Errorf("Unable to update the status of stage %d, %v", run.StageID, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		var data string
+		if runErr != nil {
+			data = runErr.Error()
+		}
+		h.Events.Publish(events.Event{
+			Type:    events.StageStatusChanged,
+			StageID: run.StageID,
+			Status:  status,
+			Data:    data,
+		})
+	}
+}
+
+// runStatus maps the final state of the stage to the run status
+func runStatus(state *pipeline.State, err error) db.Status {
+	if errors.Is(err, context.Canceled) {
+		return db.Stopped
+	}
+	if state == nil {
+		return db.Error
+	}
+	switch state.Stage.Status {
+	case droneapi.StatusPassing:
+		return db.Success
+	case droneapi.StatusKilled:
+		return db.Stopped
+	case droneapi.StatusSkipped:
+		return db.Skipped
+	}
+	return db.Error
+}
@@ -0,0 +1,447 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	droneapi "github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/drone"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	echo "github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStageRuns(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/stage/6/runs", nil)
+	rec := httptest.NewRecorder()
+	h := NewHandler(context.TODO(), getDBFile("test"), log)
+	c := e.NewContext(req, rec)
+	c.SetPath("/stage/:id/runs")
+	c.SetParamNames("id")
+	c.SetParamValues("6")
+
+	if assert.NoError(t, h.GetStageRuns(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var got db.Runs
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if assert.Len(t, got, 2) {
+			//latest run first
+			assert.Equal(t, 2, got[0].ID)
+			assert.Equal(t, db.Success, got[0].Status)
+			assert.Equal(t, []string{"display environment variables"}, got[0].Includes)
+			assert.Equal(t, 1, got[1].ID)
+			assert.Equal(t, db.Error, got[1].Status)
+			if assert.Len(t, got[1].Steps, 1) && assert.NotNil(t, got[1].Steps[0].ExitCode) {
+				assert.Equal(t, 1, *got[1].Steps[0].ExitCode)
+			}
+		}
+	}
+}
+
+func TestGetRun(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+
+	runTests := map[string]struct {
+		runID       string
+		wantStatus  db.Status
+		wantErrCode int
+	}{
+		"exists": {
+			runID:      "2",
+			wantStatus: db.Success,
+		},
+		"notFound": {
+			runID:       "20",
+			wantErrCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range runTests {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/run/"+tc.runID, nil)
+			rec := httptest.NewRecorder()
+			h := NewHandler(context.TODO(), getDBFile("test"), log)
+			c := e.NewContext(req, rec)
+			c.SetPath("/run/:id")
+			c.SetParamNames("id")
+			c.SetParamValues(tc.runID)
+
+			err := h.GetRun(c)
+			if tc.wantErrCode != 0 {
+				var he *echo.HTTPError
+				if assert.ErrorAs(t, err, &he) {
+					assert.Equal(t, tc.wantErrCode, he.Code)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				var got db.Run
+				if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, tc.wantStatus, got.Status)
+				if assert.Len(t, got.Steps, 1) && assert.NotNil(t, got.Steps[0].ExitCode) {
+					assert.Equal(t, 0, *got.Steps[0].ExitCode)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStage(t *testing.T) {
+	runTests := map[string]struct {
+		stageID     string
+		body        string
+		state       *pipeline.State
+		err         error
+		wantOpts    drone.Options
+		wantStatus  db.Status
+		wantErrCode int
+	}{
+		"passing": {
+			stageID: "1",
+			body:    `{"include":["say hello"],"trusted":true}`,
+			state: &pipeline.State{
+				Stage: &droneapi.Stage{Status: droneapi.StatusPassing},
+			},
+			wantOpts: drone.Options{
+				PipelineFile: "/tmp/examples/hello-world/.drone.yml",
+				Stage:        "default",
+				Include:      []string{"say hello"},
+				Trusted:      true,
+			},
+			wantStatus: db.Success,
+		},
+		"notCompiled": {
+			stageID: "1",
+			err:     errors.New("stage 'default' not found in build file"),
+			wantOpts: drone.Options{
+				PipelineFile: "/tmp/examples/hello-world/.drone.yml",
+				Stage:        "default",
+			},
+			wantStatus: db.Error,
+		},
+		"skipped": {
+			stageID: "1",
+			state: &pipeline.State{
+				Stage: &droneapi.Stage{Status: droneapi.StatusSkipped},
+			},
+			wantOpts: drone.Options{
+				PipelineFile: "/tmp/examples/hello-world/.drone.yml",
+				Stage:        "default",
+			},
+			wantStatus: db.Skipped,
+		},
+		"unknownStage": {
+			stageID:     "100",
+			wantErrCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range runTests {
+		t.Run(name, func(t *testing.T) {
+			if err := loadFixtures(); err != nil {
+				t.Fatal(err)
+			}
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/stage/"+tc.stageID+"/run", strings.NewReader(tc.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			h := NewHandler(context.TODO(), getDBFile("test"), log)
+			done := make(chan drone.Options, 1)
+			// the pipeline files of the fixtures are not on disk
+			h.stat = statAny
+			h.runner = func(ctx context.Context, log *logrus.Logger, opts drone.Options) (*pipeline.State, error) {
+				done <- opts
+				return tc.state, tc.err
+			}
+			eventsCh, unsubscribe := h.Events.Subscribe()
+			defer unsubscribe()
+			c := e.NewContext(req, rec)
+			c.SetPath("/stage/:id/run")
+			c.SetParamNames("id")
+			c.SetParamValues(tc.stageID)
+
+			err := h.RunStage(c)
+			if tc.wantErrCode != 0 {
+				var he *echo.HTTPError
+				if assert.ErrorAs(t, err, &he) {
+					assert.Equal(t, tc.wantErrCode, he.Code)
+				}
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			var run db.Run
+			if err := json.Unmarshal(rec.Body.Bytes(), &run); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, db.Running, run.Status)
+
+			opts := <-done
+			tc.wantOpts.RunKey = run.Key
+			assert.Equal(t, tc.wantOpts, opts)
+
+			// wait for the final status to be recorded
+			assert.Eventually(t, func() bool {
+				got := &db.Run{ID: run.ID}
+				if err := h.DatabaseConfig.DB.NewSelect().
+					Model(got).
+					WherePK().
+					Scan(context.TODO()); err != nil {
+					return false
+				}
+				return got.Status == tc.wantStatus
+			}, 5*time.Second, 50*time.Millisecond)
+
+			ev := <-eventsCh
+			assert.Equal(t, events.StageStatusChanged, ev.Type)
+			assert.Equal(t, db.Running, ev.Status)
+			// nothing else records the stage status without the monitor
+			ev = <-eventsCh
+			assert.Equal(t, events.StageStatusChanged, ev.Type)
+			assert.Equal(t, tc.wantStatus, ev.Status)
+		})
+	}
+}
+
+func TestRunStep(t *testing.T) {
+	runTests := map[string]struct {
+		stageID      string
+		stepID       string
+		wantResumeAt string
+		wantErrCode  int
+	}{
+		"resumeAt": {
+			stageID:      "1",
+			stepID:       "3",
+			wantResumeAt: "push image to registry",
+		},
+		"stepOfOtherStage": {
+			stageID:     "1",
+			stepID:      "5",
+			wantErrCode: http.StatusNotFound,
+		},
+	}
+
+	for name, tc := range runTests {
+		t.Run(name, func(t *testing.T) {
+			if err := loadFixtures(); err != nil {
+				t.Fatal(err)
+			}
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/stage/"+tc.stageID+"/step/"+tc.stepID+"/run", nil)
+			rec := httptest.NewRecorder()
+			h := NewHandler(context.TODO(), getDBFile("test"), log)
+			done := make(chan drone.Options, 1)
+			// the pipeline files of the fixtures are not on disk
+			h.stat = statAny
+			h.runner = func(ctx context.Context, log *logrus.Logger, opts drone.Options) (*pipeline.State, error) {
+				done <- opts
+				return &pipeline.State{
+					Stage: &droneapi.Stage{Status: droneapi.StatusPassing},
+				}, nil
+			}
+			c := e.NewContext(req, rec)
+			c.SetPath("/stage/:id/step/:stepId/run")
+			c.SetParamNames("id", "stepId")
+			c.SetParamValues(tc.stageID, tc.stepID)
+
+			err := h.RunStep(c)
+			if tc.wantErrCode != 0 {
+				var he *echo.HTTPError
+				if assert.ErrorAs(t, err, &he) {
+					assert.Equal(t, tc.wantErrCode, he.Code)
+				}
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			var run db.Run
+			if err := json.Unmarshal(rec.Body.Bytes(), &run); err != nil {
+				t.Fatal(err)
+			}
+			opts := <-done
+			assert.Equal(t, tc.wantResumeAt, opts.ResumeAt)
+
+			// wait for the final status to be recorded
+			assert.Eventually(t, func() bool {
+				got := &db.Run{ID: run.ID}
+				if err := h.DatabaseConfig.DB.NewSelect().
+					Model(got).
+					WherePK().
+					Scan(context.TODO()); err != nil {
+					return false
+				}
+				return got.Status == db.Success
+			}, 5*time.Second, 50*time.Millisecond)
+		})
+	}
+}
+
+func TestRunStageConflict(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	h := NewHandler(context.TODO(), getDBFile("test"), log)
+	release := make(chan struct{})
+	// the pipeline files of the fixtures are not on disk
+	h.stat = statAny
+	h.runner = func(ctx context.Context, log *logrus.Logger, opts drone.Options) (*pipeline.State, error) {
+		<-release
+		return &pipeline.State{
+			Stage: &droneapi.Stage{Status: droneapi.StatusPassing},
+		}, nil
+	}
+
+	runStage := func() error {
+		req := httptest.NewRequest(http.MethodPost, "/stage/1/run", nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.SetPath("/stage/:id/run")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		return h.RunStage(c)
+	}
+
+	assert.NoError(t, runStage())
+	// the stage is marked as running before the first run is accepted
+	err := runStage()
+	var he *echo.HTTPError
+	if assert.ErrorAs(t, err, &he) {
+		assert.Equal(t, http.StatusConflict, he.Code)
+	}
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		stage, err := h.stageByID(context.TODO(), 1)
+		return err == nil && stage.Status == db.Success
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.NoError(t, runStage())
+}
+
+func TestRunStageNotAccessible(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	h := NewHandler(context.TODO(), getDBFile("test"), log)
+	done := make(chan drone.Options, 1)
+	h.runner = func(ctx context.Context, log *logrus.Logger, opts drone.Options) (*pipeline.State, error) {
+		done <- opts
+		return &pipeline.State{
+			Stage: &droneapi.Stage{Status: droneapi.StatusPassing},
+		}, nil
+	}
+
+	runStage := func(body string) error {
+		req := httptest.NewRequest(http.MethodPost, "/stage/1/run", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.SetPath("/stage/:id/run")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		return h.RunStage(c)
+	}
+
+	// the pipeline file of the fixture is not on disk e.g. a file of a windows host
+	var he *echo.HTTPError
+	if assert.ErrorAs(t, runStage(`{}`), &he) {
+		assert.Equal(t, http.StatusUnprocessableEntity, he.Code)
+	}
+	if assert.ErrorAs(t, runStage(`{"config":"kind: pipeline","secretFile":"/tmp/examples/hello-world/.secrets"}`), &he) {
+		assert.Equal(t, http.StatusUnprocessableEntity, he.Code)
+	}
+	stage, err := h.stageByID(context.TODO(), 1)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, db.Running, stage.Status)
+	}
+
+	// the pipeline file is not read when its content is sent along
+	if assert.NoError(t, runStage(`{"config":"kind: pipeline"}`)) {
+		opts := <-done
+		assert.Equal(t, []byte("kind: pipeline"), opts.Config)
+	}
+}
+
+func TestRunStagePanic(t *testing.T) {
+	if err := loadFixtures(); err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	h := NewHandler(context.TODO(), getDBFile("test"), log)
+	h.stat = statAny
+	h.runner = func(ctx context.Context, log *logrus.Logger, opts drone.Options) (*pipeline.State, error) {
+		panic("runner failed")
+	}
+	eventsCh, unsubscribe := h.Events.Subscribe()
+	defer unsubscribe()
+
+	runStage := func() error {
+		req := httptest.NewRequest(http.MethodPost, "/stage/1/run", nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+		c.SetPath("/stage/:id/run")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		return h.RunStage(c)
+	}
+
+	if !assert.NoError(t, runStage()) {
+		return
+	}
+	assert.Equal(t, db.Running, (<-eventsCh).Status)
+	ev := <-eventsCh
+	assert.Equal(t, events.StageStatusChanged, ev.Type)
+	assert.Equal(t, db.Error, ev.Status)
+	assert.Equal(t, "run panicked, runner failed", ev.Data)
+
+	stage, err := h.stageByID(context.TODO(), 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, db.Error, stage.Status)
+	}
+	var run db.Run
+	if err := h.DatabaseConfig.DB.NewSelect().
+		Model(&run).
+		Where("stage_id = ?", 1).
+		Order("id DESC").
+		Limit(1).
+		Scan(context.TODO()); assert.NoError(t, err) {
+		assert.Equal(t, db.Error, run.Status)
+		assert.False(t, run.FinishedAt.IsZero())
+	}
+	// the run is no longer in flight and the stage could be run again
+	assert.Eventually(t, func() bool {
+		h.inflightMu.Lock()
+		defer h.inflightMu.Unlock()
+		return len(h.inflight) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.NoError(t, runStage())
+}
+
+func statAny(name string) (fs.FileInfo, error) {
+	return nil, nil
+}
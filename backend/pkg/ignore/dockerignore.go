@@ -22,8 +22,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
 
 	"k8s.io/apimachinery/pkg/util/sets"
 )
@@ -45,6 +45,8 @@ type dockerIgnorer struct {
 	directory string
 	// index of all excludes directories
 	excludedDirs sets.String
+	// excludedDirsMu guards the excludedDirs, CanIgnore is called concurrently by the walkers
+	excludedDirsMu sync.RWMutex
 	// patterns holds all the possible Ignorable patterns
 	ignorePatterns []fileIgnorePattern
 }
@@ -74,14 +76,15 @@ func NewOrDefault(dir string) (FileIgnorer, error) {
 	}
 
 	return &dockerIgnorer{
-		directory:      dir,
-		excludedDirs:   defaultPatterns,
+		directory: dir,
+		// every ignorer indexes its own excluded directories
+		excludedDirs:   sets.NewString(defaultPatterns.UnsortedList()...),
 		ignorePatterns: ignorePatterns,
 	}, nil
 }
 
 // CanIgnore is the directory scanner to scan directory for .dockerignore based
-// ignore patterns
+// ignore patterns, it is safe for concurrent use
 func (i *dockerIgnorer) CanIgnore(path string, fi os.FileInfo) (Ignorable, error) {
 	// start with assuming nothing is ignored
 	ignorable := No
@@ -104,22 +107,20 @@ func (i *dockerIgnorer) CanIgnore(path string, fi os.FileInfo) (Ignorable, error
 	hasTransitives := false
 
 	for _, igp := range i.ignorePatterns {
-		var re *regexp.Regexp
-		var err error
-
-		if re, err = regexp.Compile(igp.regExpr); err != nil {
+		re := igp.re
+		if re == nil {
 			isExcluded = false
 			continue
 		}
 		// check if the parent path is an excluded pattern in the list or current pattern
 		// matches the parent directory
-		if parentDir := filepath.Dir(path); i.excludedDirs.Has(parentDir) || re.MatchString(filepath.Dir(path)) {
+		if parentDir := filepath.Dir(path); i.isExcludedDir(parentDir) || re.MatchString(filepath.Dir(path)) {
 			// if the parent directory is not in the list check if it matches
 			// any pattern
 			if !igp.invert {
 				isExcluded = true
 				ignorable = Current
-				i.excludedDirs.Insert(parentDir)
+				i.excludeDir(parentDir)
 				continue
 			}
 		}
@@ -132,7 +133,7 @@ func (i *dockerIgnorer) CanIgnore(path string, fi os.FileInfo) (Ignorable, error
 				ignorable = No
 			} else {
 				if fi.IsDir() {
-					i.excludedDirs.Insert(path)
+					i.excludeDir(path)
 				}
 				ignorable = Current
 			}
@@ -157,6 +158,18 @@ func (i *dockerIgnorer) CanIgnore(path string, fi os.FileInfo) (Ignorable, error
 	return ignorable, nil
 }
 
+func (i *dockerIgnorer) isExcludedDir(dir string) bool {
+	i.excludedDirsMu.RLock()
+	defer i.excludedDirsMu.RUnlock()
+	return i.excludedDirs.Has(dir)
+}
+
+func (i *dockerIgnorer) excludeDir(dir string) {
+	i.excludedDirsMu.Lock()
+	defer i.excludedDirsMu.Unlock()
+	i.excludedDirs.Insert(dir)
+}
+
 // scanAndBuildPatternsList takes the file typically the .dockerignore file
 // splits the file by new line (\n) and normalize them with following rules
 // - removes the UTF8 Byte Order Mark (BOM) characters
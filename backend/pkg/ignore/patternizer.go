@@ -28,7 +28,9 @@ import (
 type fileIgnorePattern struct {
 	paths   []string
 	regExpr string
-	invert  bool
+	// re is the compiled regExpr, nil when the regExpr is not valid
+	re     *regexp.Regexp
+	invert bool
 }
 
 // sanitize the pattern to make it more file path friendly
@@ -97,6 +99,10 @@ func toFileIgnorePattern(directory, pattern string) fileIgnorePattern {
 	}
 
 	ignorePattern.regExpr = toRegExpr(directory, pattern)
+	// the patterns are compiled once rather than on every check, the invalid ones never match
+	if re, err := regexp.Compile(ignorePattern.regExpr); err == nil {
+		ignorePattern.re = re
+	}
 
 	return ignorePattern
 }
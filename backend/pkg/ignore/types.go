@@ -37,7 +37,8 @@ const (
 
 // FileIgnorer  helps identifying if a BundleFile needs to be ignored
 type FileIgnorer interface {
-	// CanIgnore checks file has to be ignored or not, returns true if it needs to be ignored
+	// CanIgnore checks file has to be ignored or not, returns true if it needs to be ignored.
+	// It is safe for concurrent use.
 	CanIgnore(path string, fi os.FileInfo) (Ignorable, error)
 }
 
@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// masked is the text that replaces the secret values in the logs
+const masked = "******"
+
+// maxPartial caps the incomplete line held by the masker e.g. of the progress bars that never
+// end the line, the line is written in parts once it is longer
+const maxPartial = 64 * 1024
+
+// masker is an io.WriteCloser that masks the secret values in the logs written to it. The logs are
+// masked line by line so that the secret values split across writes are masked too, the incomplete
+// last line is written on Close.
+type masker struct {
+	w io.Writer
+	r *strings.Replacer
+	// olds are the values to mask, the longest first
+	olds [][]byte
+	// partial holds the incomplete line that is yet to be masked
+	partial []byte
+}
+
+// newMasker returns a masker that wraps the writer w. Along with the secret values, each line of
+// the multi-line secret values and the base64 encoded secret values are masked.
+func newMasker(w io.Writer, secrets []string) *masker {
+	var olds []string
+	for _, v := range secrets {
+		if len(v) == 0 {
+			continue
+		}
+		for _, part := range strings.Split(v, "\n") {
+			part = strings.TrimSpace(part)
+			// avoid masking empty or single character strings
+			if len(part) < 2 {
+				continue
+			}
+			olds = append(olds, part)
+		}
+		// base64 encoded values as with `echo $SECRET | base64` or `printf $SECRET | base64`
+		for _, data := range []string{v, v + "\n"} {
+			for _, enc := range []*base64.Encoding{
+				base64.StdEncoding,
+				base64.RawStdEncoding,
+				base64.URLEncoding,
+				base64.RawURLEncoding,
+			} {
+				olds = append(olds, enc.EncodeToString([]byte(data)))
+			}
+		}
+	}
+
+	m := &masker{
+		w: w,
+	}
+	if len(olds) == 0 {
+		return m
+	}
+
+	// the replacements are done in the argument order, mask the longer values first
+	// so that no part of them is left unmasked
+	sort.SliceStable(olds, func(i, j int) bool {
+		return len(olds[i]) > len(olds[j])
+	})
+	oldnew := make([]string, 0, len(olds)*2)
+	for _, old := range olds {
+		oldnew = append(oldnew, old, masked)
+		m.olds = append(m.olds, []byte(old))
+	}
+	m.r = strings.NewReplacer(oldnew...)
+
+	return m
+}
+
+// Write implements io.Writer
+func (m *masker) Write(p []byte) (int, error) {
+	if m.r == nil {
+		return m.w.Write(p)
+	}
+
+	data := append(m.partial, p...)
+	n := bytes.LastIndexByte(data, '\n') + 1
+	if len(data)-n > maxPartial {
+		n = m.cut(data)
+	}
+	m.partial = append([]byte(nil), data[n:]...)
+	if n == 0 {
+		return len(p), nil
+	}
+	if _, err := io.WriteString(m.w, m.r.Replace(string(data[:n]))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// cut is where the overlong line is split, the part after it is kept for the values that are
+// yet to be completed by the next writes. A value spanning the cut is kept whole after it.
+func (m *masker) cut(data []byte) int {
+	cut := len(data) - (len(m.olds[0]) - 1)
+	// the values are matched the same as the replacer does, the longest value at the leftmost position
+	for i := 0; i < cut; {
+		matched := 0
+		for _, old := range m.olds {
+			if bytes.HasPrefix(data[i:], old) {
+				matched = len(old)
+				break
+			}
+		}
+		if matched == 0 {
+			i++
+			continue
+		}
+		if i+matched > cut {
+			return i
+		}
+		i += matched
+	}
+	return cut
+}
+
+// Close writes the incomplete last line
+func (m *masker) Close() error {
+	if len(m.partial) == 0 {
+		return nil
+	}
+	line := string(m.partial)
+	m.partial = nil
+	_, err := io.WriteString(m.w, m.r.Replace(line))
+	return err
+}
+
+// maskedSecrets gets the secret values to mask from the environment of the step container,
+// the names of the environment variables are known from the LabelMaskedEnvs label
+func maskedSecrets(info types.ContainerJSON) []string {
+	if info.Config == nil {
+		return nil
+	}
+	names := info.Config.Labels[LabelMaskedEnvs]
+	if names == "" {
+		return nil
+	}
+	masked := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		masked[name] = true
+	}
+
+	var secrets []string
+	for _, env := range info.Config.Env {
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) == 2 && masked[parts[0]] {
+			secrets = append(secrets, parts[1])
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,123 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMasker(t *testing.T) {
+	secrets := []string{
+		"s3cr3t",
+		"-----BEGIN KEY-----\nMIIEvQIBADANBg\n-----END KEY-----",
+		"x",
+	}
+	maskTests := map[string]struct {
+		writes []string
+		want   string
+	}{
+		"plain": {
+			writes: []string{"password is s3cr3t\n"},
+			want:   "password is ******\n",
+		},
+		"splitAcrossWrites": {
+			writes: []string{"password is s3c", "r3t\nbye\n"},
+			want:   "password is ******\nbye\n",
+		},
+		"multiLine": {
+			writes: []string{"-----BEGIN KEY-----\nMIIEvQIBADANBg\n-----END KEY-----\n"},
+			want:   "******\n******\n******\n",
+		},
+		"base64": {
+			writes: []string{base64.StdEncoding.EncodeToString([]byte("s3cr3t\n")) + "\n"},
+			want:   "******\n",
+		},
+		"base64NoPadding": {
+			writes: []string{"token=" + base64.RawURLEncoding.EncodeToString([]byte("s3cr3t")) + "\n"},
+			want:   "token=******\n",
+		},
+		"singleCharacterNotMasked": {
+			writes: []string{"x marks the spot"},
+			want:   "x marks the spot",
+		},
+	}
+
+	for name, tc := range maskTests {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			m := newMasker(&buf, secrets)
+			for _, w := range tc.writes {
+				n, err := m.Write([]byte(w))
+				if assert.NoError(t, err) {
+					assert.Equal(t, len(w), n)
+				}
+			}
+			assert.NoError(t, m.Close())
+			assert.Equal(t, tc.want, buf.String())
+		})
+	}
+}
+
+func TestMaskerOverlongLine(t *testing.T) {
+	var buf bytes.Buffer
+	m := newMasker(&buf, []string{"s3cr3t"})
+	longest := len(m.olds[0])
+	line := strings.Repeat("=", maxPartial)
+
+	// the line is written once it is too long, the start of the value is kept for the next write
+	if _, err := m.Write([]byte(line + "s3c")); err != nil {
+		t.Fatal(err)
+	}
+	assert.Less(t, len(m.partial), longest)
+	assert.Equal(t, line[:maxPartial+3-(longest-1)], buf.String())
+	if _, err := m.Write([]byte("r3t==")); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, m.Close())
+	assert.Equal(t, line+"******==", buf.String())
+
+	// a value spanning the cut is kept whole for it to be masked
+	buf.Reset()
+	m = newMasker(&buf, []string{"s3cr3t"})
+	if _, err := m.Write([]byte(line + "s3cr3t" + strings.Repeat("=", longest-4))); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, line, buf.String())
+	assert.NoError(t, m.Close())
+	assert.Equal(t, line+"******"+strings.Repeat("=", longest-4), buf.String())
+}
+
+func TestMaskerWithoutSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	m := newMasker(&buf, nil)
+	if _, err := m.Write([]byte("no new line")); err != nil {
+		t.Fatal(err)
+	}
+	// written as is without waiting for the line to complete
+	assert.Equal(t, "no new line", buf.String())
+	assert.NoError(t, m.Close())
+}
+
+func TestMaskedSecrets(t *testing.T) {
+	info := types.ContainerJSON{
+		Config: &container.Config{
+			Labels: map[string]string{
+				LabelMaskedEnvs: "PASSWORD,TOKEN",
+			},
+			Env: []string{
+				"HOME=/root",
+				"PASSWORD=s3cr3t",
+				"TOKEN=a=b",
+			},
+		},
+	}
+	assert.Equal(t, []string{"s3cr3t", "a=b"}, maskedSecrets(info))
+
+	info.Config.Labels = nil
+	assert.Empty(t, maskedSecrets(info))
+}
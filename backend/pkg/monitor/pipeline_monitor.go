@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"path"
 	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/events"
+	dockerevents "github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
 	"github.com/harness/drone-ci-docker-extension/pkg/utils"
 	"github.com/labstack/gommon/log"
 	"github.com/sirupsen/logrus"
@@ -36,10 +38,17 @@ func WithLogsPath(logsPath string) Option {
 	}
 }
 
+// WithEvents sets the broker to publish the stage and step events
+func WithEvents(broker *events.Broker) Option {
+	return func(c *Config) {
+		c.Events = broker
+	}
+}
+
 func New(ctx context.Context, db *bun.DB, log *logrus.Logger, options ...Option) (*Config, error) {
 	var err error
 	filters := filters.NewArgs()
-	filters.Add("type", events.ContainerEventType)
+	filters.Add("type", dockerevents.ContainerEventType)
 	filters.Add("event", "start")
 	filters.Add("event", "die")
 	filters.Add("scope", "local")
@@ -47,8 +56,6 @@ func New(ctx context.Context, db *bun.DB, log *logrus.Logger, options ...Option)
 	filters.Add("label", LabelStageName)
 	filters.Add("label", LabelStepName)
 
-	//TODO add since flag to filter events since the app started
-
 	cfg := &Config{
 		DB:            db,
 		Ctx:           ctx,
@@ -61,6 +68,9 @@ func New(ctx context.Context, db *bun.DB, log *logrus.Logger, options ...Option)
 	for _, o := range options {
 		o(cfg)
 	}
+	if cfg.Events == nil {
+		cfg.Events = events.NewBroker(ctx, log)
+	}
 	cfg.DockerCli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
@@ -73,12 +83,16 @@ func (c *Config) MonitorAndLog() {
 	log := c.Log
 	log.Info("Started to Monitor and log pipeline runs")
 
-	dbConn := c.DB
-
 	msgCh, errCh := c.DockerCli.Events(c.Ctx, types.EventsOptions{
 		Filters: c.filters,
 	})
 
+	// the events are subscribed before reconciling so that the containers
+	// that start or die while reconciling are not missed
+	if err := c.Reconcile(); err != nil {
+		log.Errorf("Error reconciling the pipeline state, %v", err)
+	}
+
 	for {
 		select {
 		case err := <-errCh:
@@ -89,105 +103,178 @@ func (c *Config) MonitorAndLog() {
 		case msg := <-msgCh:
 			actor := msg.Actor
 			log.Tracef("Message \n%#v\n", msg)
-			go func(actor events.Actor, msg events.Message) {
-				log2 := utils.LogSetup(log.Out, log.Level.String())
-				log2.Tracef("Actor \n%#v\n", actor)
-				pipelineFile := actor.Attributes[LabelPipelineFile]
-				var includes, excludes []string
-				if v, ok := actor.Attributes[LabelIncludes]; ok {
-					if v != "" {
-						includes = strings.Split(v, ",")
-					}
-				}
-				if v, ok := actor.Attributes[LabelExcludes]; ok {
-					if v != "" {
-						excludes = strings.Split(v, ",")
-					}
-				}
-				stageName := actor.Attributes[LabelStageName]
-				stepName := actor.Attributes[LabelStepName]
-				var stage = &db.Stage{}
-				count, err := dbConn.NewSelect().
-					Model(stage).
-					Relation("Steps").
-					Where("name = ? and pipeline_file = ? ", stageName, pipelineFile).
-					ScanAndCount(c.Ctx)
-
-				if err != nil {
-					log2.Errorf("Error monitoring logs %v", err)
-					c.MonitorErrors <- err
+			if c.isReplayed(actor.ID, msg.Status) {
+				log.Debugf("Ignoring %s of container %s, it was replayed", msg.Status, actor.ID)
+				continue
+			}
+			go c.handleStepEvent(msg.Status, actor.Attributes, time.Unix(0, msg.TimeNano))
+		}
+	}
+}
+
+// handleStepEvent handles the start and die of the step container identified by its attributes. The statuses of
+// the stage and the step are updated, the run is recorded and the logs of the step are saved.
+func (c *Config) handleStepEvent(status string, attrs map[string]string, eventTime time.Time) {
+	log := c.Log
+	dbConn := c.DB
+	log2 := utils.LogSetup(log.Out, log.Level.String())
+	log2.Tracef("Attributes \n%#v\n", attrs)
+	if c.isReported(attrs[LabelRunKey]) {
+		log2.Debugf("Ignoring %s of step %s, the run is reported by the backend", status, attrs[LabelStepName])
+		return
+	}
+	pipelineFile := attrs[LabelPipelineFile]
+	var includes, excludes []string
+	if v, ok := attrs[LabelIncludes]; ok {
+		if v != "" {
+			includes = strings.Split(v, ",")
+		}
+	}
+	if v, ok := attrs[LabelExcludes]; ok {
+		if v != "" {
+			excludes = strings.Split(v, ",")
+		}
+	}
+	stageName := attrs[LabelStageName]
+	stepName := attrs[LabelStepName]
+	var stage = &db.Stage{}
+	count, err := dbConn.NewSelect().
+		Model(stage).
+		Relation("Steps").
+		Where("name = ? and pipeline_file = ? ", stageName, pipelineFile).
+		ScanAndCount(c.Ctx)
+
+	if err != nil {
+		log2.Errorf("Error monitoring logs %v", err)
+		c.MonitorErrors <- err
+	}
+	// the steps before the step the run resumed at keep their statuses
+	if v := attrs[LabelResumeAt]; v != "" {
+		stage.Steps = ResumeSteps(stage.Steps, v)
+	}
+	log2.Debugf("Includes %v", includes)
+	log2.Debugf("Excludes %v", excludes)
+	if len(includes) > 0 {
+		i, e := FilterSteps(stage.Steps, includes)
+		updateStepStatus(c.Ctx, dbConn, e)
+		stage.Steps = i
+	}
+	if len(excludes) > 0 {
+		i, e := FilterSteps(stage.Steps, excludes)
+		updateStepStatus(c.Ctx, dbConn, e)
+		stage.Steps = i
+	}
+	if v := attrs[LabelSkipped]; v != "" {
+		r, s := SkipSteps(stage.Steps, strings.Split(v, ","))
+		if len(s) > 0 {
+			updateStepStatus(c.Ctx, dbConn, s)
+		}
+		stage.Steps = r
+	}
+	if count == 1 {
+		log2.Tracef("Stage %#v", stage)
+		pipelineLogPath := utils.StageLogsPath(c.LogsPath, stage.ID)
+		if err := os.MkdirAll(pipelineLogPath, 0744); err != nil {
+			err := fmt.Errorf("unable to create pipeline logs folder %s %w", pipelineLogPath, err)
+			log2.Error(err)
+			c.MonitorErrors <- err
+		}
+		switch status {
+		case "start":
+			log2.Infof("Starting Step Name %s", stepName)
+			stepIdx := getRunningStepIndex(stage, stepName)
+			run, err := c.stageRun(stage, attrs, includes, excludes, eventTime, stepIdx == 0)
+			if err != nil {
+				err := fmt.Errorf("unable to record run of stage %s %w", stageName, err)
+				log2.Error(err)
+				c.MonitorErrors <- err
+				return
+			}
+			if err := os.MkdirAll(run.LogsPath, 0744); err != nil {
+				err := fmt.Errorf("unable to create run logs folder %s %w", run.LogsPath, err)
+				log2.Error(err)
+				c.MonitorErrors <- err
+			}
+			go c.writeLogs(pipelineLogPath, run.LogsPath, stage.ID, attrs)
+			if err := c.recordStepStart(run, stage.Steps[stepIdx], eventTime); err != nil {
+				log2.Errorf("Error recording start of step %s, %v", stepName, err)
+				c.MonitorErrors <- err
+			}
+			//Resetting the status of the steps
+			//All steps from the current step identified by stepName
+			//are set to status == db.None
+			//currently running step will have running status
+			stage.Steps[stepIdx].Status = db.Running
+			startTiming(stage.Steps[stepIdx], eventTime)
+			for i := stepIdx + 1; i < len(stage.Steps); i++ {
+				if stage.Steps[i].Service != 1 {
+					stage.Steps[i].Status = db.None
+					resetTiming(stage.Steps[i])
 				}
-				log2.Debugf("Includes %v", includes)
-				log2.Debugf("Excludes %v", excludes)
-				if len(includes) > 0 {
-					i, e := FilterSteps(stage.Steps, includes)
-					updateStepStatus(c.Ctx, dbConn, e)
-					stage.Steps = i
+			}
+			if stepIdx == 0 {
+				stage.StartedAt = eventTime
+				stage.FinishedAt = time.Time{}
+				stage.Duration = 0
+			}
+			//update the stage to be running if current step is the first step
+			c.updateStatuses(stage, stage.Steps[stepIdx], stepIdx == 0)
+		case "die":
+			_, isService := attrs[LabelService]
+			log2.Tracef("Dying Step Name %s, attributes %#v", stepName, attrs)
+			stepIdx := getRunningStepIndex(stage, stepName)
+			var stepStatus db.Status
+			exitCode := attrs["exitCode"]
+			log2.Infof("Dying Step Name %s, Exit Code %s", stepName, exitCode)
+			if exitCode == "0" {
+				stepStatus = db.Success
+			} else if exitCode == "137" {
+				// Service containers are killed with exit code 137
+				// we can treat them as step success
+				if isService {
+					stepStatus = db.Success
+				} else { // when Pipeline is killed steps are killed with eit code 137, we can treat them to be stopped
+					stepStatus = db.Stopped
 				}
-				if len(excludes) > 0 {
-					i, e := FilterSteps(stage.Steps, excludes)
-					updateStepStatus(c.Ctx, dbConn, e)
-					stage.Steps = i
+			} else {
+				stepStatus = db.Error
+			}
+			stage.Steps[stepIdx].Status = stepStatus
+			finishTiming(stage.Steps[stepIdx], eventTime)
+			// update the overall stage status only
+			// if the current step is last step
+			// or any error occurred
+			// or the pipeline is stopped
+			updateStage := stepIdx == len(stage.Steps)-1 || stepStatus == db.Error || stepStatus == db.Stopped
+			if updateStage {
+				stage.FinishedAt = eventTime
+				if !stage.StartedAt.IsZero() {
+					stage.Duration = eventTime.Sub(stage.StartedAt).Milliseconds()
 				}
-				if count == 1 {
-					log2.Tracef("Stage %#v", stage)
-					pipelineLogPath := path.Join(c.LogsPath, fmt.Sprintf("%d", stage.ID))
-					if err := os.MkdirAll(pipelineLogPath, 0744); err != nil {
-						err := fmt.Errorf("unable to create pipeline logs folder %s %w", pipelineLogPath, err)
-						log2.Error(err)
-						c.MonitorErrors <- err
-					}
-					switch msg.Status {
-					case "start":
-						go c.writeLogs(pipelineLogPath, actor.Attributes)
-						log2.Infof("Starting Step Name %s", stepName)
-						//Resetting the status of the steps
-						//All steps from the current step identified by stepName
-						//are set to status == db.None
-						stepIdx := getRunningStepIndex(stage, stepName)
-						//currently running step will have running status
-						stage.Steps[stepIdx].Status = db.Running
-						for i := stepIdx + 1; i < len(stage.Steps); i++ {
-							if stage.Steps[i].Service != 1 {
-								stage.Steps[i].Status = db.None
-							}
-						}
-						//update the stage to be running if current step is the first step
-						c.updateStatuses(stage, stepIdx == 0)
-					case "die":
-						_, isService := actor.Attributes[LabelService]
-						log2.Tracef("Dying Step Name %s, attributes %#v", stepName, actor.Attributes)
-						stepIdx := getRunningStepIndex(stage, stepName)
-						var stepStatus db.Status
-						exitCode := actor.Attributes["exitCode"]
-						log2.Infof("Dying Step Name %s, Exit Code %s", stepName, exitCode)
-						if exitCode == "0" {
-							stepStatus = db.Success
-						} else if exitCode == "137" {
-							// Service containers are killed with exit code 137
-							// we can treat them as step success
-							if isService {
-								stepStatus = db.Success
-							} else { // when Pipeline is killed steps are killed with eit code 137, we can treat them to be stopped
-								stepStatus = db.Stopped
-							}
-						} else {
-							stepStatus = db.Error
-						}
-						stage.Steps[stepIdx].Status = stepStatus
-						// update the overall stage status only
-						// if the current step is last step
-						// or any error occurred
-						// or the pipeline is stopped
-						c.updateStatuses(stage, stepIdx == len(stage.Steps)-1 || stepStatus == db.Error || stepStatus == db.Stopped)
-					default:
-						//no requirement to handle other cases
-					}
-				} else {
-					c.MonitorErrors <- fmt.Errorf("unable to find stage %s in pipeline %s", stageName, pipelineFile)
+			}
+			c.updateStatuses(stage, stage.Steps[stepIdx], updateStage)
+			run, err := c.stageRun(stage, attrs, includes, excludes, eventTime, false)
+			if err != nil {
+				err := fmt.Errorf("unable to record run of stage %s %w", stageName, err)
+				log2.Error(err)
+				c.MonitorErrors <- err
+				return
+			}
+			if err := c.recordStepEnd(run, stage.Steps[stepIdx], exitCode, eventTime); err != nil {
+				log2.Errorf("Error recording end of step %s, %v", stepName, err)
+				c.MonitorErrors <- err
+			}
+			if updateStage {
+				if err := c.finishRun(run, stage.Status, eventTime); err != nil {
+					log2.Errorf("Error recording end of run %d, %v", run.ID, err)
+					c.MonitorErrors <- err
 				}
-			}(actor, msg)
+			}
+		default:
+			//no requirement to handle other cases
 		}
+	} else {
+		c.MonitorErrors <- fmt.Errorf("unable to find stage %s in pipeline %s", stageName, pipelineFile)
 	}
 }
 
@@ -205,12 +292,51 @@ func FilterSteps(steps []*db.StageStep, filters []string) (i db.Steps, e db.Step
 		} else {
 			//Erase old statuses if any
 			step.Status = db.None
+			resetTiming(step)
 			e = append(e, step)
 		}
 	}
 	return
 }
 
+// SkipSteps marks the steps with the skipped names as Skipped.
+// Returns the steps to run and the skipped steps
+func SkipSteps(steps db.Steps, skipped []string) (r db.Steps, s db.Steps) {
+	m := make(map[string]bool)
+	for _, item := range skipped {
+		m[item] = true
+	}
+
+	for _, step := range steps {
+		if m[step.Name] {
+			step.Status = db.Skipped
+			resetTiming(step)
+			s = append(s, step)
+		} else {
+			r = append(r, step)
+		}
+	}
+	return
+}
+
+// ResumeSteps gets the steps from the step named resumeAt onwards along with the services,
+// all the steps are returned when there is no such step
+func ResumeSteps(steps db.Steps, resumeAt string) (r db.Steps) {
+	resumed := false
+	for _, step := range steps {
+		if step.Name == resumeAt {
+			resumed = true
+		}
+		if resumed || step.Service == 1 {
+			r = append(r, step)
+		}
+	}
+	if !resumed {
+		return steps
+	}
+	return
+}
+
 func getRunningStepIndex(stage *db.Stage, stepName string) int {
 	var stepIdx int
 	for i, st := range stage.Steps {
@@ -222,7 +348,9 @@ func getRunningStepIndex(stage *db.Stage, stepName string) int {
 	return stepIdx
 }
 
-func (c *Config) updateStatuses(stage *db.Stage, updateStage bool) {
+// updateStatuses updates the statuses of the stage steps and if updateStage is set, the stage status.
+// Once updated, the events of the step and stage status changes are published.
+func (c *Config) updateStatuses(stage *db.Stage, step *db.StageStep, updateStage bool) {
 	dbConn := c.DB
 	log := c.Log
 	if err := dbConn.RunInTx(c.Ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
@@ -237,15 +365,39 @@ func (c *Config) updateStatuses(stage *db.Stage, updateStage bool) {
 				return err
 			}
 		}
-		return utils.TriggerUIRefresh(c.Ctx, c.DockerCli, c.Log)
+		return nil
 	}); err != nil {
 		c.MonitorErrors <- err
+		return
+	}
+
+	eventType := events.StepFinished
+	if step.Status == db.Running {
+		eventType = events.StepStarted
+	}
+	c.Events.Publish(events.Event{
+		Type:     eventType,
+		StageID:  stage.ID,
+		StepID:   step.ID,
+		StepName: step.Name,
+		Status:   step.Status,
+	})
+	if updateStage {
+		c.Events.Publish(events.Event{
+			Type:    events.StageStatusChanged,
+			StageID: stage.ID,
+			Status:  stage.Status,
+		})
 	}
 }
 
 func updateStageStatus(ctx context.Context, dbConn bun.IDB, stage *db.Stage) error {
 	var status db.Status
 	for _, step := range stage.Steps {
+		// skipped steps do not affect the status of the stage
+		if step.Status == db.Skipped {
+			continue
+		}
 		if step.Status >= status {
 			status = step.Status
 		}
@@ -272,6 +424,9 @@ func updateStepStatus(ctx context.Context, dbConn bun.IDB, steps db.Steps) error
 		Model((*db.StageStep)(nil)).
 		Table("_data").
 		Set("status = _data.status").
+		Set("started_at = _data.started_at").
+		Set("finished_at = _data.finished_at").
+		Set("duration = _data.duration").
 		Where("st.id = _data.id").
 		Exec(ctx)
 
@@ -286,7 +441,50 @@ func updateStepStatus(ctx context.Context, dbConn bun.IDB, steps db.Steps) error
 	return nil
 }
 
-func (c *Config) writeLogs(pipelineLogPath string, attrs map[string]string) {
+// startTiming records the start of the step, clearing the timing of its earlier run
+func startTiming(step *db.StageStep, startedAt time.Time) {
+	step.StartedAt = startedAt
+	step.FinishedAt = time.Time{}
+	step.Duration = 0
+}
+
+// finishTiming records the end of the step along with the time it took
+func finishTiming(step *db.StageStep, finishedAt time.Time) {
+	step.FinishedAt = finishedAt
+	if !step.StartedAt.IsZero() {
+		step.Duration = finishedAt.Sub(step.StartedAt).Milliseconds()
+	}
+}
+
+// resetTiming clears the timing of the step that is yet to run
+func resetTiming(step *db.StageStep) {
+	step.StartedAt = time.Time{}
+	step.FinishedAt = time.Time{}
+	step.Duration = 0
+}
+
+// logPublisher publishes the log content written to it as events.LogAppended
+type logPublisher struct {
+	events   *events.Broker
+	stageID  int
+	stepName string
+}
+
+// Write implements io.Writer
+func (p *logPublisher) Write(b []byte) (int, error) {
+	p.events.Publish(events.Event{
+		Type:     events.LogAppended,
+		StageID:  p.stageID,
+		StepName: p.stepName,
+		Status:   db.Running,
+		Data:     string(b),
+	})
+	return len(b), nil
+}
+
+// writeLogs saves the logs of the step container in the run logs folder runLogsPath and
+// links it as the latest logs of the step in the pipelineLogPath
+func (c *Config) writeLogs(pipelineLogPath, runLogsPath string, stageID int, attrs map[string]string) {
 	options := types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true, Tail: "true"}
 	c.Log.Tracef("Actor Attributes %#v", attrs)
 	out, err := c.DockerCli.ContainerLogs(c.Ctx, attrs["name"], options)
@@ -295,14 +493,34 @@ func (c *Config) writeLogs(pipelineLogPath string, attrs map[string]string) {
 		log.Error(err)
 		c.MonitorErrors <- err
 	} else {
-		containerLogPath := path.Join(pipelineLogPath, fmt.Sprintf("%s.log", utils.Md5OfString(attrs[LabelStepName])))
+		defer out.Close()
+		containerLogPath := utils.StepLogFile(runLogsPath, attrs[LabelStepName])
 		f, err := os.OpenFile(containerLogPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err == nil {
+			err = linkLatestLog(pipelineLogPath, containerLogPath)
+		}
 		if err != nil {
 			err := fmt.Errorf("error writing logs for container %s, %w ", attrs[LabelStepName], err)
 			log.Error(err)
 			c.MonitorErrors <- err
 		} else {
-			if _, err := io.Copy(f, out); err != nil {
+			defer f.Close()
+			var secrets []string
+			if info, err := c.DockerCli.ContainerInspect(c.Ctx, attrs["name"]); err != nil {
+				c.Log.Warnf("Unable to inspect container %s to mask its secrets, %v", attrs["name"], err)
+			} else {
+				secrets = maskedSecrets(info)
+			}
+			// the secrets are masked before the logs are saved or published
+			w := newMasker(io.MultiWriter(f, &logPublisher{
+				events:   c.Events,
+				stageID:  stageID,
+				stepName: attrs[LabelStepName],
+			}), secrets)
+			defer w.Close()
+			// step containers are not run with tty, demultiplex the stdout and stderr
+			// streams so that the log file holds only the container output
+			if _, err := stdcopy.StdCopy(w, w, out); err != nil {
 				err := fmt.Errorf("error copying logs for container %s, %w ", attrs[LabelStepName], err)
 				log.Error(err)
 				c.MonitorErrors <- err
@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepTiming(t *testing.T) {
+	startedAt := time.Date(2022, 10, 1, 10, 0, 0, 0, time.UTC)
+	step := &db.StageStep{
+		Name:       "say hello",
+		FinishedAt: startedAt.Add(-time.Hour),
+		Duration:   1000,
+	}
+
+	startTiming(step, startedAt)
+	assert.Equal(t, startedAt, step.StartedAt)
+	assert.True(t, step.FinishedAt.IsZero(), "Expecting the timing of the earlier run to be cleared")
+	assert.Equal(t, int64(0), step.Duration)
+
+	finishTiming(step, startedAt.Add(1500*time.Millisecond))
+	assert.Equal(t, startedAt.Add(1500*time.Millisecond), step.FinishedAt)
+	assert.Equal(t, int64(1500), step.Duration)
+
+	resetTiming(step)
+	assert.True(t, step.StartedAt.IsZero())
+	assert.True(t, step.FinishedAt.IsZero())
+	assert.Equal(t, int64(0), step.Duration)
+}
+
+func TestSkipSteps(t *testing.T) {
+	steps := db.Steps{
+		{ID: 1, Name: "build", Status: db.Success},
+		{ID: 2, Name: "publish", Status: db.Success, Duration: 1000},
+		{ID: 3, Name: "notify"},
+	}
+
+	r, s := SkipSteps(steps, []string{"publish"})
+	if assert.Len(t, r, 2) {
+		assert.Equal(t, "build", r[0].Name)
+		assert.Equal(t, "notify", r[1].Name)
+	}
+	if assert.Len(t, s, 1) {
+		assert.Equal(t, db.Skipped, s[0].Status)
+		assert.Equal(t, int64(0), s[0].Duration)
+	}
+}
+
+func TestResumeSteps(t *testing.T) {
+	steps := db.Steps{
+		{ID: 1, Name: "redis", Service: 1, Status: db.Success},
+		{ID: 2, Name: "build", Status: db.Success},
+		{ID: 3, Name: "test", Status: db.Error},
+		{ID: 4, Name: "publish"},
+	}
+
+	r := ResumeSteps(steps, "test")
+	if assert.Len(t, r, 3) {
+		assert.Equal(t, "redis", r[0].Name)
+		assert.Equal(t, "test", r[1].Name)
+		assert.Equal(t, "publish", r[2].Name)
+	}
+	assert.Equal(t, db.Success, steps[1].Status)
+
+	assert.Equal(t, steps, ResumeSteps(steps, "deploy"))
+}
@@ -0,0 +1,192 @@
+package monitor
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+)
+
+// Reconcile replays the state of the existing step containers into the stages and their steps, so that the
+// runs that progressed while the backend was not running are up to date. The logs of the step containers are
+// captured again. The running stages that have no running step containers are marked as Stopped.
+func (c *Config) Reconcile() error {
+	log := c.Log
+	containers, err := c.DockerCli.ContainerList(c.Ctx, types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", LabelPipelineFile),
+			filters.Arg("label", LabelStageName),
+			filters.Arg("label", LabelStepName),
+		),
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("Reconciling %d step containers", len(containers))
+
+	stepContainers := make([]stepContainer, 0, len(containers))
+	seen := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		// a container starting while listing may be listed twice
+		if seen[container.ID] {
+			continue
+		}
+		seen[container.ID] = true
+		info, err := c.DockerCli.ContainerInspect(c.Ctx, container.ID)
+		if err != nil {
+			return err
+		}
+		sc := toStepContainer(info)
+		// the container was created but never started
+		if sc.startedAt.IsZero() {
+			continue
+		}
+		stepContainers = append(stepContainers, sc)
+	}
+
+	// replay the containers in the order they were started
+	sort.SliceStable(stepContainers, func(i, j int) bool {
+		return stepContainers[i].startedAt.Before(stepContainers[j].startedAt)
+	})
+
+	live := make(map[string]bool)
+	for _, sc := range stepContainers {
+		log.Debugf("Replaying step container %s", sc.attrs["name"])
+		c.replay(sc.id, "start")
+		c.handleStepEvent("start", sc.attrs, sc.startedAt)
+		switch {
+		case sc.running:
+			live[stageKey(sc.attrs[LabelPipelineFile], sc.attrs[LabelStageName])] = true
+		case sc.exited:
+			c.replay(sc.id, "die")
+			sc.attrs["exitCode"] = strconv.Itoa(sc.exitCode)
+			c.handleStepEvent("die", sc.attrs, sc.finishedAt)
+		}
+	}
+
+	return c.stopStagesNotLive(live)
+}
+
+// stepContainer is the state of a step container that is replayed
+type stepContainer struct {
+	id string
+	// attrs are the attributes as in the container events i.e. the labels along with the container name
+	attrs map[string]string
+	// a container being removed or restarted has neither exited nor is running
+	running    bool
+	exited     bool
+	exitCode   int
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+func toStepContainer(info types.ContainerJSON) stepContainer {
+	attrs := make(map[string]string)
+	for k, v := range info.Config.Labels {
+		attrs[k] = v
+	}
+	attrs["name"] = strings.TrimPrefix(info.Name, "/")
+
+	sc := stepContainer{
+		id:       info.ID,
+		attrs:    attrs,
+		running:  info.State.Running,
+		exited:   info.State.Status == "exited" || info.State.Status == "dead",
+		exitCode: info.State.ExitCode,
+	}
+	// the zero time of docker is used for the containers that never started or finished
+	if t, err := time.Parse(time.RFC3339Nano, info.State.StartedAt); err == nil && t.After(time.Unix(0, 0)) {
+		sc.startedAt = t
+	}
+	if t, err := time.Parse(time.RFC3339Nano, info.State.FinishedAt); err == nil && t.After(time.Unix(0, 0)) {
+		sc.finishedAt = t
+	}
+	return sc
+}
+
+// replay records that the status of the container is replayed so that its docker event is ignored
+func (c *Config) replay(containerID, status string) {
+	c.replayedMu.Lock()
+	defer c.replayedMu.Unlock()
+	if c.replayed == nil {
+		c.replayed = make(map[string]bool)
+	}
+	c.replayed[containerID+"/"+status] = true
+}
+
+// isReplayed reports whether the status of the container was replayed, the status is no longer
+// replayed afterwards as the docker event of it is received at most once
+func (c *Config) isReplayed(containerID, status string) bool {
+	c.replayedMu.Lock()
+	defer c.replayedMu.Unlock()
+	key := containerID + "/" + status
+	if c.replayed[key] {
+		delete(c.replayed, key)
+		return true
+	}
+	return false
+}
+
+func stageKey(pipelineFile, stageName string) string {
+	return pipelineFile + "#" + stageName
+}
+
+// stopStagesNotLive marks the running stages without live step containers as Stopped,
+// along with their steps and runs that are yet to finish
+func (c *Config) stopStagesNotLive(live map[string]bool) error {
+	log := c.Log
+	stages := make(db.Stages, 0)
+	if err := c.DB.NewSelect().
+		Model(&stages).
+		Where("status = ?", db.Running).
+		Scan(c.Ctx); err != nil {
+		return err
+	}
+
+	for _, stage := range stages {
+		if live[stageKey(stage.PipelineFile, stage.Name)] {
+			continue
+		}
+		log.Infof("Stopping stage %s of pipeline %s as it has no running steps", stage.Name, stage.PipelineFile)
+		steps, err := c.stopStage(stage)
+		if err != nil {
+			return err
+		}
+		for _, step := range steps {
+			c.Events.Publish(events.Event{
+				Type:     events.StepFinished,
+				StageID:  stage.ID,
+				StepID:   step.ID,
+				StepName: step.Name,
+				Status:   db.Stopped,
+			})
+		}
+		c.Events.Publish(events.Event{
+			Type:    events.StageStatusChanged,
+			StageID: stage.ID,
+			Status:  db.Stopped,
+		})
+	}
+
+	return nil
+}
+
+// stopStage marks the stage, its running runs and the steps that are yet to finish as Stopped.
+// The steps that were stopped are returned.
+func (c *Config) stopStage(stage *db.Stage) (db.Steps, error) {
+	var runIDs []int
+	if err := c.DB.NewSelect().
+		Model((*db.Run)(nil)).
+		Column("id").
+		Where("stage_id = ? AND status = ?", stage.ID, db.Running).
+		Scan(c.Ctx, &runIDs); err != nil {
+		return nil, err
+	}
+	return db.StopStage(c.Ctx, c.DB, stage.ID, runIDs)
+}
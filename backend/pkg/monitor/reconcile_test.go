@@ -0,0 +1,160 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToStepContainer(t *testing.T) {
+	info := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:   "abc123",
+			Name: "/drone-abc123",
+			State: &types.ContainerState{
+				Status:     "exited",
+				Running:    false,
+				ExitCode:   137,
+				StartedAt:  "2022-10-01T10:00:00.5Z",
+				FinishedAt: "2022-10-01T10:00:05Z",
+			},
+		},
+		Config: &container.Config{
+			Labels: map[string]string{
+				LabelPipelineFile: "/tmp/examples/hello-world/.drone.yml",
+				LabelStageName:    "default",
+				LabelStepName:     "say hello",
+			},
+		},
+	}
+
+	got := toStepContainer(info)
+	assert.Equal(t, map[string]string{
+		LabelPipelineFile: "/tmp/examples/hello-world/.drone.yml",
+		LabelStageName:    "default",
+		LabelStepName:     "say hello",
+		"name":            "drone-abc123",
+	}, got.attrs)
+	assert.Equal(t, "abc123", got.id)
+	assert.False(t, got.running)
+	assert.True(t, got.exited)
+	assert.Equal(t, 137, got.exitCode)
+	assert.Equal(t, time.Date(2022, 10, 1, 10, 0, 0, 500000000, time.UTC), got.startedAt)
+	assert.Equal(t, time.Date(2022, 10, 1, 10, 0, 5, 0, time.UTC), got.finishedAt)
+
+	// a container being removed has not exited
+	info.State.Status = "removing"
+	assert.False(t, toStepContainer(info).exited)
+
+	// a created container that never started carries the zero time of docker
+	info.State.StartedAt = "0001-01-01T00:00:00Z"
+	info.State.FinishedAt = "0001-01-01T00:00:00Z"
+	got = toStepContainer(info)
+	assert.True(t, got.startedAt.IsZero())
+	assert.True(t, got.finishedAt.IsZero())
+}
+
+func TestIsReplayed(t *testing.T) {
+	c := &Config{}
+	assert.False(t, c.isReplayed("abc123", "start"))
+
+	c.replay("abc123", "start")
+	assert.False(t, c.isReplayed("abc123", "die"))
+	assert.True(t, c.isReplayed("abc123", "start"))
+	// the event of a replayed status is ignored once
+	assert.False(t, c.isReplayed("abc123", "start"))
+}
+
+func TestStopStagesNotLive(t *testing.T) {
+	c := newTestConfig(t)
+	ctx, dbConn, broker := c.Ctx, c.DB, c.Events
+
+	stages := db.Stages{
+		{Name: "default", PipelineFile: "/tmp/live/.drone.yml", PipelinePath: "/tmp/live", Status: db.Running},
+		{Name: "default", PipelineFile: "/tmp/dead/.drone.yml", PipelinePath: "/tmp/dead", Status: db.Running},
+		{Name: "default", PipelineFile: "/tmp/done/.drone.yml", PipelinePath: "/tmp/done", Status: db.Success},
+	}
+	if _, err := dbConn.NewInsert().Model(&stages).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dead := stages[1]
+	steps := db.Steps{
+		{StageID: dead.ID, Name: "build", Image: "golang", Status: db.Success},
+		{StageID: dead.ID, Name: "test", Image: "golang", Status: db.Running},
+		{StageID: dead.ID, Name: "push", Image: "plugins/docker"},
+	}
+	if _, err := dbConn.NewInsert().Model(&steps).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now().Add(-time.Minute)
+	run := &db.Run{StageID: dead.ID, Key: "run-1", Status: db.Running, StartedAt: started}
+	if _, err := dbConn.NewInsert().Model(run).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	stepRun := &db.StepRun{RunID: run.ID, StepID: steps[1].ID, StepName: "test", Status: db.Running, StartedAt: started}
+	if _, err := dbConn.NewInsert().Model(stepRun).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	live := map[string]bool{stageKey("/tmp/live/.drone.yml", "default"): true}
+	if err := c.stopStagesNotLive(live); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses := make(map[string]db.Status)
+	var got db.Stages
+	if err := dbConn.NewSelect().Model(&got).Relation("Steps").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, stage := range got {
+		statuses[stage.PipelineFile] = stage.Status
+		if stage.ID != dead.ID {
+			continue
+		}
+		for _, s := range stage.Steps {
+			statuses[s.Name] = s.Status
+		}
+	}
+	assert.Equal(t, map[string]db.Status{
+		"/tmp/live/.drone.yml": db.Running,
+		"/tmp/dead/.drone.yml": db.Stopped,
+		"/tmp/done/.drone.yml": db.Success,
+		"build":                db.Success,
+		"test":                 db.Stopped,
+		"push":                 db.Stopped,
+	}, statuses)
+
+	if err := dbConn.NewSelect().Model(run).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, db.Stopped, run.Status)
+	assert.False(t, run.FinishedAt.IsZero())
+	if err := dbConn.NewSelect().Model(stepRun).WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, db.Stopped, stepRun.Status)
+	assert.False(t, stepRun.FinishedAt.IsZero())
+
+	var published []events.Event
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-ch:
+			published = append(published, e)
+		case <-time.After(time.Second):
+			t.Fatal("Expecting the events of the stopped stage")
+		}
+	}
+	assert.Equal(t, events.StepFinished, published[0].Type)
+	assert.Equal(t, events.StepFinished, published[1].Type)
+	assert.Equal(t, events.StageStatusChanged, published[2].Type)
+	assert.Equal(t, dead.ID, published[2].StageID)
+	assert.Equal(t, db.Stopped, published[2].Status)
+}
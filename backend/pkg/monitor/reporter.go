@@ -0,0 +1,318 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+)
+
+var (
+	_ pipeline.Reporter = (*Reporter)(nil)
+	_ pipeline.Streamer = (*Reporter)(nil)
+)
+
+// Reporter reports the step and stage state changes of a run straight to the database and streams the step
+// logs to the log files of the run, as the run is executed. While the reporter is open the docker events of
+// the step containers of the run are ignored, the monitor falls back to them for the runs executed elsewhere
+// e.g. drone exec from the CLI, or when the backend restarts during the run.
+type Reporter struct {
+	c     *Config
+	stage *db.Stage
+	run   *db.Run
+	// mu serializes the updates of the stage and its steps
+	mu sync.Mutex
+	// started is set once the steps of the run are reset
+	started bool
+	// secrets are the secret values to mask in the logs keyed by the step name
+	secrets map[string][]string
+}
+
+// NewReporter opens a reporter for the run of the stage, the run must have been recorded with its key and logs path.
+// The reporter must be closed once the run is done.
+func (c *Config) NewReporter(stage *db.Stage, run *db.Run) (*Reporter, error) {
+	steps := make(db.Steps, 0)
+	if err := c.DB.NewSelect().
+		Model(&steps).
+		Where("stage_id = ?", stage.ID).
+		Order("id ASC").
+		Scan(c.Ctx); err != nil {
+		return nil, err
+	}
+	stage.Steps = steps
+
+	c.reportedMu.Lock()
+	if c.reported == nil {
+		c.reported = make(map[string]bool)
+	}
+	c.reported[run.Key] = true
+	c.reportedMu.Unlock()
+
+	return &Reporter{
+		c:     c,
+		stage: stage,
+		run:   run,
+	}, nil
+}
+
+// Close hands the run back to the monitor
+func (r *Reporter) Close() error {
+	r.c.reportedMu.Lock()
+	delete(r.c.reported, r.run.Key)
+	r.c.reportedMu.Unlock()
+	return nil
+}
+
+// isReported reports whether the run with the key is reported by a Reporter
+func (c *Config) isReported(runKey string) bool {
+	c.reportedMu.Lock()
+	defer c.reportedMu.Unlock()
+	return runKey != "" && c.reported[runKey]
+}
+
+// ReportStage implements pipeline.Reporter, the final status of the stage and the run are recorded.
+// The errors are logged rather than returned so that they never fail the run.
+func (r *Reporter) ReportStage(_ context.Context, state *pipeline.State) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state.Lock()
+	status := toStatus(state.Stage.Status)
+	finishedAt := unixTime(state.Stage.Stopped)
+	state.Unlock()
+	if finishedAt.IsZero() {
+		finishedAt = time.Now()
+	}
+
+	stage := r.stage
+	stage.Status = status
+	stage.FinishedAt = finishedAt
+	// the stage never started when its environment could not be set up
+	if r.started {
+		stage.Duration = finishedAt.Sub(stage.StartedAt).Milliseconds()
+	}
+	if _, err := r.c.DB.NewUpdate().
+		Model(stage).
+		Column("status", "started_at", "finished_at", "duration").
+		WherePK().
+		Exec(r.c.Ctx); err != nil {
+		r.c.Log.Errorf("Error reporting the status of stage %s, %v", stage.Name, err)
+		return nil
+	}
+	if err := r.c.finishRun(r.run, status, finishedAt); err != nil {
+		r.c.Log.Errorf("Error reporting the end of run %d, %v", r.run.ID, err)
+	}
+	r.c.Events.Publish(events.Event{
+		Type:    events.StageStatusChanged,
+		StageID: stage.ID,
+		Status:  status,
+	})
+	return nil
+}
+
+// ReportStep implements pipeline.Reporter, the status and timing of the step are recorded. Only the steps that
+// are part of the run are updated, the steps left out e.g. before the step the run resumed at keep their statuses.
+func (r *Reporter) ReportStep(_ context.Context, state *pipeline.State, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.started {
+		r.start(state)
+	}
+
+	s := state.Find(name)
+	state.Lock()
+	status := toStatus(s.Status)
+	exitCode := s.ExitCode
+	startedAt, finishedAt := unixTime(s.Started), unixTime(s.Stopped)
+	state.Unlock()
+
+	step := r.step(name)
+	if step == nil {
+		// e.g. the clone step that is not a step of the stage
+		return nil
+	}
+	step.Status = status
+	switch status {
+	case db.Running:
+		startTiming(step, startedAt)
+		if err := r.c.recordStepStart(r.run, step, startedAt); err != nil {
+			r.c.Log.Errorf("Error reporting the start of step %s, %v", name, err)
+		}
+	case db.Skipped:
+		resetTiming(step)
+	default:
+		finishTiming(step, finishedAt)
+		if err := r.c.recordStepEnd(r.run, step, strconv.Itoa(exitCode), finishedAt); err != nil {
+			r.c.Log.Errorf("Error reporting the end of step %s, %v", name, err)
+		}
+	}
+	r.c.updateStatuses(r.stage, step, false)
+	return nil
+}
+
+// start resets the statuses of the steps of the run and marks the stage as running
+func (r *Reporter) start(state *pipeline.State) {
+	r.started = true
+	state.Lock()
+	statuses := make(map[string]db.Status)
+	for _, s := range state.Stage.Steps {
+		statuses[s.Name] = toStatus(s.Status)
+	}
+	state.Unlock()
+
+	steps := make(db.Steps, 0)
+	for _, step := range r.stage.Steps {
+		status, ok := statuses[step.Name]
+		if !ok {
+			continue
+		}
+		step.Status = status
+		resetTiming(step)
+		steps = append(steps, step)
+	}
+	if len(steps) > 0 {
+		if err := updateStepStatus(r.c.Ctx, r.c.DB, steps); err != nil {
+			r.c.Log.Errorf("Error resetting the steps of stage %s, %v", r.stage.Name, err)
+		}
+	}
+
+	r.stage.Status = db.Running
+	r.stage.StartedAt = time.Now()
+	r.stage.FinishedAt = time.Time{}
+	r.stage.Duration = 0
+	if _, err := r.c.DB.NewUpdate().
+		Model(r.stage).
+		Column("status", "started_at", "finished_at", "duration").
+		WherePK().
+		Exec(r.c.Ctx); err != nil {
+		r.c.Log.Errorf("Error reporting the start of stage %s, %v", r.stage.Name, err)
+		return
+	}
+	r.c.Events.Publish(events.Event{
+		Type:    events.StageStatusChanged,
+		StageID: r.stage.ID,
+		Status:  db.Running,
+	})
+}
+
+func (r *Reporter) step(name string) *db.StageStep {
+	for _, step := range r.stage.Steps {
+		if step.Name == name {
+			return step
+		}
+	}
+	return nil
+}
+
+// MaskSecrets sets the secret values of the steps to mask in the logs, keyed by the step name
+func (r *Reporter) MaskSecrets(secrets map[string][]string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = secrets
+}
+
+// Stream implements pipeline.Streamer, the logs of the step are saved in the run logs folder, linked as the latest
+// logs of the step and published. The secrets of the step are masked the same as in the logs of the step containers.
+func (r *Reporter) Stream(_ context.Context, _ *pipeline.State, name string) io.WriteCloser {
+	r.mu.Lock()
+	secrets := r.secrets[name]
+	r.mu.Unlock()
+	stageLogsPath := utils.StageLogsPath(r.c.LogsPath, r.stage.ID)
+	stepLogFile := utils.StepLogFile(r.run.LogsPath, name)
+	publisher := &logPublisher{
+		events:   r.c.Events,
+		stageID:  r.stage.ID,
+		stepName: name,
+	}
+
+	f, err := openStepLogFile(stageLogsPath, r.run.LogsPath, stepLogFile)
+	if err != nil {
+		r.c.Log.Errorf("Error writing logs of step %s, %v", name, err)
+		m := newMasker(publisher, secrets)
+		return &logStream{w: m, c: m}
+	}
+	m := newMasker(io.MultiWriter(f, publisher), secrets)
+	return &logStream{
+		w: m,
+		c: multiCloser{m, f},
+	}
+}
+
+// multiCloser closes all of its closers in order, returning the first error
+type multiCloser []io.Closer
+
+func (mc multiCloser) Close() error {
+	var err error
+	for _, c := range mc {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func openStepLogFile(stageLogsPath, runLogsPath, stepLogFile string) (*os.File, error) {
+	if err := os.MkdirAll(runLogsPath, 0744); err != nil {
+		return nil, fmt.Errorf("unable to create run logs folder %s %w", runLogsPath, err)
+	}
+	f, err := os.OpenFile(stepLogFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := linkLatestLog(stageLogsPath, stepLogFile); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// logStream writes the step logs to w and closes c, if any, on Close
+type logStream struct {
+	w io.Writer
+	c io.Closer
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *logStream) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
+
+// toStatus maps the status of the drone stage or step to the status of the extension
+func toStatus(status string) db.Status {
+	switch status {
+	case drone.StatusRunning:
+		return db.Running
+	case drone.StatusPassing:
+		return db.Success
+	case drone.StatusFailing, drone.StatusError:
+		return db.Error
+	case drone.StatusKilled:
+		return db.Stopped
+	case drone.StatusSkipped:
+		return db.Skipped
+	}
+	return db.None
+}
+
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/drone/drone-go/drone"
+	"github.com/drone/runner-go/pipeline"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestConfig configures the monitor with a new database and logs path in a temp directory
+func newTestConfig(t *testing.T) *Config {
+	ctx := context.TODO()
+	log := utils.LogSetup(os.Stdout, "debug")
+	dir := t.TempDir()
+	dbc := db.New(
+		db.WithContext(ctx),
+		db.WithDBFile(path.Join(dir, "monitor.db")),
+		db.WithLogger(log))
+	dbc.Init()
+	t.Cleanup(func() {
+		dbc.DB.Close()
+	})
+
+	return &Config{
+		Ctx:           ctx,
+		Log:           log,
+		DB:            dbc.DB,
+		LogsPath:      path.Join(dir, "logs"),
+		MonitorErrors: make(chan error, 10),
+		Events:        events.NewBroker(ctx, log),
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	assert.Equal(t, db.Running, toStatus(drone.StatusRunning))
+	assert.Equal(t, db.Success, toStatus(drone.StatusPassing))
+	assert.Equal(t, db.Error, toStatus(drone.StatusFailing))
+	assert.Equal(t, db.Error, toStatus(drone.StatusError))
+	assert.Equal(t, db.Stopped, toStatus(drone.StatusKilled))
+	assert.Equal(t, db.Skipped, toStatus(drone.StatusSkipped))
+	assert.Equal(t, db.None, toStatus(drone.StatusPending))
+}
+
+func TestIsReported(t *testing.T) {
+	c := &Config{}
+	assert.False(t, c.isReported("run-1"))
+
+	r := &Reporter{c: c, run: &db.Run{Key: "run-1"}}
+	c.reported = map[string]bool{"run-1": true}
+	assert.True(t, c.isReported("run-1"))
+	assert.False(t, c.isReported(""))
+
+	r.Close()
+	assert.False(t, c.isReported("run-1"))
+}
+
+func TestReporter(t *testing.T) {
+	c := newTestConfig(t)
+	ctx := c.Ctx
+
+	stage := &db.Stage{
+		Name:         "default",
+		PipelineFile: "/tmp/examples/hello-world/.drone.yml",
+		PipelinePath: "/tmp/examples/hello-world",
+		Status:       db.Success,
+	}
+	if _, err := c.DB.NewInsert().Model(stage).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	steps := db.Steps{
+		{StageID: stage.ID, Name: "build", Image: "golang", Status: db.Success},
+		{StageID: stage.ID, Name: "test", Image: "golang", Status: db.Success},
+	}
+	if _, err := c.DB.NewInsert().Model(&steps).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	run := &db.Run{StageID: stage.ID, Key: "run-1", Status: db.Running, StartedAt: time.Now()}
+	if _, err := c.DB.NewInsert().Model(run).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	run.LogsPath = utils.RunLogsPath(utils.StageLogsPath(c.LogsPath, stage.ID), run.ID)
+
+	r, err := c.NewReporter(stage, run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, c.isReported("run-1"))
+	r.MaskSecrets(map[string][]string{"build": {"s3cr3t"}})
+
+	started := time.Date(2022, 10, 1, 10, 0, 0, 0, time.UTC)
+	state := &pipeline.State{
+		Stage: &drone.Stage{
+			Status: drone.StatusRunning,
+			Steps: []*drone.Step{
+				{Name: "clone", Status: drone.StatusPassing},
+				{Name: "build", Status: drone.StatusRunning, Started: started.Unix()},
+				{Name: "test", Status: drone.StatusPending},
+			},
+		},
+	}
+	report := func(name, status string, stopped time.Time, exitCode int) {
+		s := state.Find(name)
+		s.Status = status
+		if !stopped.IsZero() {
+			s.Stopped = stopped.Unix()
+		}
+		s.ExitCode = exitCode
+		assert.NoError(t, r.ReportStep(ctx, state, name))
+	}
+
+	// the steps that are not part of the stage are ignored
+	assert.NoError(t, r.ReportStep(ctx, state, "clone"))
+	report("build", drone.StatusRunning, time.Time{}, 0)
+
+	w := r.Stream(ctx, state, "build")
+	_, err = io.WriteString(w, "token s3cr3t\n")
+	assert.NoError(t, err)
+	_, err = io.WriteString(w, "done")
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	report("build", drone.StatusPassing, started.Add(5*time.Second), 0)
+	state.Find("test").Started = started.Add(5 * time.Second).Unix()
+	report("test", drone.StatusRunning, time.Time{}, 0)
+	report("test", drone.StatusFailing, started.Add(7*time.Second), 1)
+
+	finished := started.Add(8 * time.Second)
+	state.Stage.Status = drone.StatusFailing
+	state.Stage.Stopped = finished.Unix()
+	assert.NoError(t, r.ReportStage(ctx, state))
+	assert.NoError(t, r.Close())
+	assert.False(t, c.isReported("run-1"))
+	assert.Empty(t, c.MonitorErrors)
+
+	got := &db.Stage{ID: stage.ID}
+	if err := c.DB.NewSelect().Model(got).Relation("Steps").WherePK().Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, db.Error, got.Status)
+	assert.Equal(t, finished.Unix(), got.FinishedAt.Unix())
+	assert.Equal(t, finished.Sub(got.StartedAt).Milliseconds(), got.Duration)
+	assert.Len(t, got.Steps, 2)
+	for _, s := range got.Steps {
+		switch s.Name {
+		case "build":
+			assert.Equal(t, db.Success, s.Status)
+			assert.Equal(t, started.Unix(), s.StartedAt.Unix())
+			assert.Equal(t, int64(5000), s.Duration)
+		case "test":
+			assert.Equal(t, db.Error, s.Status)
+			assert.Equal(t, int64(2000), s.Duration)
+		}
+	}
+
+	gotRun := &db.Run{ID: run.ID}
+	if err := c.DB.NewSelect().
+		Model(gotRun).
+		Relation("Steps").
+		WherePK().
+		Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, db.Error, gotRun.Status)
+	assert.Equal(t, finished.Unix(), gotRun.FinishedAt.Unix())
+	stepRuns := make(map[string]*db.StepRun)
+	for _, sr := range gotRun.Steps {
+		stepRuns[sr.StepName] = sr
+	}
+	if assert.Len(t, stepRuns, 2) {
+		build, test := stepRuns["build"], stepRuns["test"]
+		assert.Equal(t, db.Success, build.Status)
+		assert.Equal(t, 0, *build.ExitCode)
+		assert.Equal(t, started.Unix(), build.StartedAt.Unix())
+		assert.Equal(t, started.Add(5*time.Second).Unix(), build.FinishedAt.Unix())
+		assert.Equal(t, utils.StepLogFile(run.LogsPath, "build"), build.LogFile)
+		assert.Equal(t, db.Error, test.Status)
+		assert.Equal(t, 1, *test.ExitCode)
+	}
+
+	// the masked logs are saved in the run and linked as the latest logs of the step
+	logs, err := os.ReadFile(utils.StepLogFile(run.LogsPath, "build"))
+	assert.NoError(t, err)
+	assert.Equal(t, "token ******\ndone", string(logs))
+	latest := filepath.Join(utils.StageLogsPath(c.LogsPath, stage.ID), filepath.Base(utils.StepLogFile(run.LogsPath, "build")))
+	logs, err = os.ReadFile(latest)
+	assert.NoError(t, err)
+	assert.Equal(t, "token ******\ndone", string(logs))
+}
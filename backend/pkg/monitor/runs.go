@@ -0,0 +1,138 @@
+package monitor
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/uptrace/bun"
+)
+
+// stageRun gets the run of the stage that the step container belongs to. The run is identified using
+// the LabelRunKey label of the container, when the label is not available the latest run of the stage is used.
+// A new run is recorded when none exists or when the container belongs to the first step of the stage.
+func (c *Config) stageRun(stage *db.Stage, attrs map[string]string, includes, excludes []string, startedAt time.Time, firstStep bool) (*db.Run, error) {
+	c.runsMu.Lock()
+	defer c.runsMu.Unlock()
+
+	dbConn := c.DB
+	run := new(db.Run)
+	key := attrs[LabelRunKey]
+	q := dbConn.NewSelect().
+		Model(run).
+		Where("stage_id = ?", stage.ID)
+	lookup := true
+	if key != "" {
+		q = q.Where("? = ?", bun.Ident("key"), key)
+	} else {
+		lookup = !firstStep
+		q = q.Order("id DESC").Limit(1)
+		key = strconv.FormatInt(startedAt.UnixNano(), 10)
+	}
+
+	if lookup {
+		err := q.Scan(c.Ctx)
+		if err == nil {
+			return run, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	run = &db.Run{
+		StageID:   stage.ID,
+		Key:       key,
+		Status:    db.Running,
+		Includes:  includes,
+		Excludes:  excludes,
+		StartedAt: startedAt,
+	}
+	if _, err := dbConn.NewInsert().
+		Model(run).
+		Exec(c.Ctx); err != nil {
+		return nil, err
+	}
+
+	run.LogsPath = utils.RunLogsPath(utils.StageLogsPath(c.LogsPath, stage.ID), run.ID)
+	if _, err := dbConn.NewUpdate().
+		Model(run).
+		Column("logs_path").
+		WherePK().
+		Exec(c.Ctx); err != nil {
+		return nil, err
+	}
+
+	c.Log.Infof("Recorded run %d of stage %s", run.ID, stage.Name)
+
+	return run, nil
+}
+
+// recordStepStart records the start of the step as part of the run
+func (c *Config) recordStepStart(run *db.Run, step *db.StageStep, startedAt time.Time) error {
+	stepRun := &db.StepRun{
+		RunID:     run.ID,
+		StepID:    step.ID,
+		StepName:  step.Name,
+		Status:    db.Running,
+		LogFile:   utils.StepLogFile(run.LogsPath, step.Name),
+		StartedAt: startedAt,
+	}
+	_, err := c.DB.NewInsert().
+		Model(stepRun).
+		On("CONFLICT(run_id,step_name) DO UPDATE").
+		Set("status = excluded.status").
+		Set("log_file = excluded.log_file").
+		Set("started_at = excluded.started_at").
+		Exec(c.Ctx)
+	return err
+}
+
+// recordStepEnd records the status and exit code of the step as part of the run
+func (c *Config) recordStepEnd(run *db.Run, step *db.StageStep, exitCode string, finishedAt time.Time) error {
+	stepRun := &db.StepRun{
+		Status:     step.Status,
+		FinishedAt: finishedAt,
+	}
+	if code, err := strconv.Atoi(exitCode); err == nil {
+		stepRun.ExitCode = &code
+	}
+	_, err := c.DB.NewUpdate().
+		Model(stepRun).
+		Column("status", "exit_code", "finished_at", "modified_at").
+		Where("run_id = ? AND step_name = ?", run.ID, step.Name).
+		Exec(c.Ctx)
+	return err
+}
+
+// finishRun records the final status of the run
+func (c *Config) finishRun(run *db.Run, status db.Status, finishedAt time.Time) error {
+	run.Status = status
+	run.FinishedAt = finishedAt
+	_, err := c.DB.NewUpdate().
+		Model(run).
+		Column("status", "finished_at", "modified_at").
+		WherePK().
+		Exec(c.Ctx)
+	return err
+}
+
+// linkLatestLog links the stage step log file to the log file of the latest run,
+// so that the stage logs always refer to the logs of the latest run
+func linkLatestLog(stageLogsPath, runLogFile string) error {
+	stageLogFile := filepath.Join(stageLogsPath, filepath.Base(runLogFile))
+	target, err := filepath.Rel(stageLogsPath, runLogFile)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(stageLogFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("unable to remove the old log file %s, %w", stageLogFile, err)
+	}
+	return os.Symlink(target, stageLogFile)
+}
@@ -2,10 +2,11 @@ package monitor
 
 import (
 	"context"
+	"sync"
 
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
-	"github.com/harness/drone-ci-docker-extension/pkg/handler"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
 	"github.com/sirupsen/logrus"
 	"github.com/uptrace/bun"
 )
@@ -18,8 +19,16 @@ type Config struct {
 	DB            *bun.DB
 	LogsPath      string
 	MonitorErrors chan error
+	Events        *events.Broker
 	filters       filters.Args
-	Handler       *handler.Handler
+	runsMu        sync.Mutex
+	// reported are the keys of the runs reported by a Reporter, their docker events are ignored
+	reported   map[string]bool
+	reportedMu sync.Mutex
+	// replayed are the start and die of the containers replayed by Reconcile, keyed by the container ID
+	// and the status, their docker events received while reconciling are ignored
+	replayed   map[string]bool
+	replayedMu sync.Mutex
 }
 
 type Monitor interface {
@@ -43,4 +52,12 @@ const (
 	LabelStepNumber = "io.drone.step.number"
 	//LabelService to identify if the step is a "Service"
 	LabelService = "io.drone.desktop.pipeline.service"
+	//LabelRunKey is to identify the run i.e. single execution of the stage
+	LabelRunKey = "io.drone.desktop.pipeline.run.key"
+	//LabelSkipped is to hold list of steps skipped as their when conditions are not met, as comma separated string
+	LabelSkipped = "io.drone.desktop.pipeline.skipped"
+	//LabelMaskedEnvs is to hold the names of the environment variables carrying masked secrets as comma separated string
+	LabelMaskedEnvs = "io.drone.desktop.pipeline.masked.envs"
+	//LabelResumeAt is to hold the name of the step the run resumed at, the steps before it are not run
+	LabelResumeAt = "io.drone.desktop.pipeline.resume.at"
 )
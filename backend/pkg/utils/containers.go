@@ -18,7 +18,8 @@ const (
 
 // TriggerUIRefresh starts a container to notify the extension UI to reload the progress actions from the cache.
 // The container uses the label "io.drone.desktop.ui.refresh=true" for that purpose and is auto-removed when exited.
-// The extension UI is listening for container events with that label. Once an event is received, the extension UI sends a ui refresh action to refresh and reload the pipelines from backend
+// Clients that listen for container events with that label can reload the pipelines from the backend once an event is received.
+// This is only a fallback for the clients that can't subscribe to the events endpoint GET /events, enabled with the backend flag -ui-refresh-container
 func TriggerUIRefresh(ctx context.Context, cli *client.Client, log *logrus.Logger) error {
 	log.Debugf("Trigger UI Refresh")
 	// Ensure the image is present before creating the container
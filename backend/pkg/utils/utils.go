@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
+	"strconv"
 
 	"github.com/labstack/gommon/log"
 	"github.com/sirupsen/logrus"
@@ -15,7 +17,7 @@ func LogSetup(out io.Writer, level string) *logrus.Logger {
 	lvl, err := logrus.ParseLevel(level)
 
 	if err != nil {
-		log.Warnf("Unable to use the %s level, %#v. Defaulting to warning.")
+		log.Warnf("Unable to use the %s level, %#v. Defaulting to warning.", level, err)
 		lvl = logrus.WarnLevel
 	}
 
@@ -42,9 +44,38 @@ func LookupEnvOrString(envName, defaultVal string) string {
 	return defaultVal
 }
 
+// LookupEnvOrBool looks up an environment variable and parses it as bool,
+// if not found or not a valid bool returns defaultVal
+func LookupEnvOrBool(envName string, defaultVal bool) bool {
+	if val, ok := os.LookupEnv(envName); ok {
+		if b, err := strconv.ParseBool(val); err == nil {
+			return b
+		}
+	}
+
+	return defaultVal
+}
+
 // Md5OfString returns the md5 has of the string
 // Its ok to use md5 hashing here as it just used
 // for consistent and sanitized naming
 func Md5OfString(str string) string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(str)))
 }
+
+// StageLogsPath returns the directory that holds the step logs of the stage
+// identified by stageID
+func StageLogsPath(logsPath string, stageID int) string {
+	return path.Join(logsPath, fmt.Sprintf("%d", stageID))
+}
+
+// RunLogsPath returns the directory that holds the step logs of a run of the stage
+func RunLogsPath(stageLogsPath string, runID int) string {
+	return path.Join(stageLogsPath, "runs", fmt.Sprintf("%d", runID))
+}
+
+// StepLogFile returns the log file of the step stepName within the stage logs
+// directory stageLogsPath. The file name is md5 of the step name to keep it sanitized.
+func StepLogFile(stageLogsPath, stepName string) string {
+	return path.Join(stageLogsPath, fmt.Sprintf("%s.log", Md5OfString(stepName)))
+}
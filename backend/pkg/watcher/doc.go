@@ -0,0 +1,20 @@
+/*
+Copyright 2022 Kamesh Sampath<kamesh.sampath@hotmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package watcher keeps the stages of the imported pipelines in sync with their pipeline files.
+// The directories of the pipeline files are watched for changes, a changed file is parsed again
+// and the new stages and steps are saved while the steps removed from the file are deleted.
+package watcher
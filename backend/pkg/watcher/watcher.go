@@ -0,0 +1,462 @@
+package watcher
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/harness/drone-ci-docker-extension/pkg/finder"
+	"github.com/sirupsen/logrus"
+	"github.com/uptrace/bun"
+)
+
+// Watcher watches the pipeline files of the stages in the database and updates the stages
+// when the files change
+type Watcher struct {
+	Ctx    context.Context
+	DB     *bun.DB
+	Log    *logrus.Logger
+	Events *events.Broker
+	// debounce is the time to wait for the changes of a file to settle e.g. an editor
+	// truncating and writing the file
+	debounce time.Duration
+
+	fsw *fsnotify.Watcher
+	mu  sync.Mutex
+	// files are the pipeline files and the files they import watched keyed by their directory, the
+	// files are keyed by their clean path to match the events and map to the paths the stages of
+	// the pipeline files to update are saved with
+	files map[string]map[string][]string
+	// imports are the files imported by the Jsonnet and Starlark pipeline files keyed by the path
+	// the stages are saved with
+	imports map[string][]string
+	// timers are the pending updates of the pipeline files
+	timers map[string]*time.Timer
+	// updateMu serializes the updates of the stages
+	updateMu sync.Mutex
+}
+
+// Option configures the Watcher
+type Option func(*Watcher)
+
+// WithEvents sets the broker to publish the changes of the pipelines
+func WithEvents(broker *events.Broker) Option {
+	return func(w *Watcher) {
+		w.Events = broker
+	}
+}
+
+// WithDebounce sets the time to wait after the last change of a file before it is parsed again,
+// defaults to 500ms
+func WithDebounce(debounce time.Duration) Option {
+	return func(w *Watcher) {
+		if debounce > 0 {
+			w.debounce = debounce
+		}
+	}
+}
+
+// New creates a new Watcher of the pipeline files of the stages in the database
+func New(ctx context.Context, db *bun.DB, log *logrus.Logger, options ...Option) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		Ctx:      ctx,
+		DB:       db,
+		Log:      log,
+		debounce: 500 * time.Millisecond,
+		fsw:      fsw,
+		files:    make(map[string]map[string][]string),
+		imports:  make(map[string][]string),
+		timers:   make(map[string]*time.Timer),
+	}
+	for _, o := range options {
+		o(w)
+	}
+	if w.Events == nil {
+		w.Events = events.NewBroker(ctx, log)
+	}
+	return w, nil
+}
+
+// Sync watches the pipeline files of the stages in the database along with the files they import
+// and stops watching the ones that are no longer imported. It has to be called whenever the stages
+// are saved or deleted. The files are watched at the same paths as on the host, the directories of
+// the host that are not mounted in the container could not be watched.
+func (w *Watcher) Sync() error {
+	var stages db.Stages
+	err := w.DB.NewSelect().
+		Model(&stages).
+		Column("pipeline_file").
+		Scan(w.Ctx)
+	if err != nil {
+		return err
+	}
+
+	pipelineFiles := make(map[string]bool)
+	for _, stage := range stages {
+		pipelineFiles[stage.PipelineFile] = true
+	}
+	// the imports of the pipeline files are known once the files have been read
+	var unknown []string
+	w.mu.Lock()
+	for pipelineFile := range pipelineFiles {
+		if _, ok := w.imports[pipelineFile]; !ok {
+			unknown = append(unknown, pipelineFile)
+		}
+	}
+	w.mu.Unlock()
+	read := make(map[string][]string, len(unknown))
+	for _, pipelineFile := range unknown {
+		_, imports, _ := finder.ReadStagesWithImports(pipelineFile)
+		read[pipelineFile] = imports
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for pipelineFile, imports := range read {
+		w.imports[pipelineFile] = imports
+	}
+	files := make(map[string]map[string][]string)
+	watch := func(file, pipelineFile string) {
+		file = filepath.Clean(file)
+		dir := filepath.Dir(file)
+		if files[dir] == nil {
+			files[dir] = make(map[string][]string)
+		}
+		files[dir][file] = append(files[dir][file], pipelineFile)
+	}
+	for pipelineFile := range w.imports {
+		if !pipelineFiles[pipelineFile] {
+			delete(w.imports, pipelineFile)
+		}
+	}
+	for pipelineFile := range pipelineFiles {
+		watch(pipelineFile, pipelineFile)
+		for _, file := range w.imports[pipelineFile] {
+			watch(file, pipelineFile)
+		}
+	}
+
+	// the directories are watched rather than the files, the editors that save by
+	// renaming a new file over the old one would end the watch of the file
+	for dir := range w.files {
+		if _, ok := files[dir]; !ok {
+			w.Log.Debugf("Stop watching %s", dir)
+			if err := w.fsw.Remove(dir); err != nil {
+				w.Log.Debugf("Error removing watch of %s, %v", dir, err)
+			}
+		}
+	}
+	for dir := range files {
+		if _, ok := w.files[dir]; ok {
+			continue
+		}
+		if err := w.fsw.Add(dir); err != nil {
+			w.Log.Warnf("Unable to watch %s, the changes of its files are not picked up, %v", dir, err)
+			delete(files, dir)
+			continue
+		}
+		w.Log.Debugf("Watching %s", dir)
+	}
+	w.files = files
+
+	return nil
+}
+
+// Watch updates the stages of the changed pipeline files until the context is done
+func (w *Watcher) Watch() {
+	defer w.fsw.Close()
+	for {
+		select {
+		case <-w.Ctx.Done():
+			w.mu.Lock()
+			for _, t := range w.timers {
+				t.Stop()
+			}
+			w.mu.Unlock()
+			return
+		case e, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.changed(e)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			w.Log.Errorf("Error watching the pipeline files, %v", err)
+		}
+	}
+}
+
+// changed schedules the update of the stages of the changed pipeline file or of the pipeline files
+// importing the changed file, the update is pushed back by the further changes
+func (w *Watcher) changed(e fsnotify.Event) {
+	// a removed or renamed file is either gone or followed by the create of the new file
+	if e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+		return
+	}
+	file := filepath.Clean(e.Name)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, pipelineFile := range w.files[filepath.Dir(file)][file] {
+		w.schedule(pipelineFile)
+	}
+}
+
+// schedule updates the stages of the pipeline file once the debounce has passed, w.mu is to be held
+func (w *Watcher) schedule(pipelineFile string) {
+	if t, ok := w.timers[pipelineFile]; ok {
+		t.Reset(w.debounce)
+		return
+	}
+	w.timers[pipelineFile] = time.AfterFunc(w.debounce, func() {
+		w.mu.Lock()
+		delete(w.timers, pipelineFile)
+		w.mu.Unlock()
+		if err := w.Update(pipelineFile); err != nil {
+			w.Log.Errorf("Error updating the stages of %s, %v", pipelineFile, err)
+		}
+	})
+}
+
+// Update parses the pipeline file and updates its stages in the database, the new stages and steps
+// are saved and the steps no longer in the file are deleted. The stages no longer in the file are
+// kept along with their runs, to be deleted from the extension. The stages are left as is while
+// the file could not be parsed. The files imported by the pipeline file are watched from then on.
+func (w *Watcher) Update(file string) error {
+	w.updateMu.Lock()
+	defer w.updateMu.Unlock()
+	log := w.Log
+	parsed, imports, errs := finder.ReadStagesWithImports(file)
+	if err := w.syncImports(file, imports); err != nil {
+		log.Warnf("Unable to watch the imports of %s, %v", file, err)
+	}
+	if len(errs) > 0 {
+		for _, e := range errs {
+			log.Warnf("Not updating the stages of %s, %s", file, e.Message)
+		}
+		return nil
+	}
+
+	var stored db.Stages
+	err := w.DB.NewSelect().
+		Model(&stored).
+		Relation("Steps").
+		Where("pipeline_file = ?", file).
+		Scan(w.Ctx)
+	if err != nil {
+		return err
+	}
+	storedByName := make(map[string]*db.Stage, len(stored))
+	for _, stage := range stored {
+		storedByName[stage.Name] = stage
+	}
+
+	var changed bool
+	err = w.DB.RunInTx(w.Ctx, &sql.TxOptions{}, func(ctx context.Context, tx bun.Tx) error {
+		for _, p := range parsed {
+			stage := toStage(p)
+			have, ok := storedByName[stage.Name]
+			if !ok {
+				log.Infof("Adding stage %s of %s", stage.Name, file)
+				changed = true
+				if _, err := tx.NewInsert().Model(stage).Exec(ctx); err != nil {
+					return err
+				}
+				if len(stage.Steps) == 0 {
+					continue
+				}
+				for _, s := range stage.Steps {
+					s.StageID = stage.ID
+				}
+				if _, err := tx.NewInsert().Model(&stage.Steps).Exec(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+			delete(storedByName, stage.Name)
+
+			stage.ID = have.ID
+			if !sameStage(have, stage) {
+				changed = true
+				_, err := tx.NewUpdate().
+					Model(stage).
+					Column("kind", "type", "depends_on", "trigger", "platform").
+					WherePK().
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+			}
+
+			stepsChanged, err := updateSteps(ctx, tx, have, stage.Steps)
+			if err != nil {
+				return err
+			}
+			changed = changed || stepsChanged
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name := range storedByName {
+		log.Infof("Stage %s is no longer in %s, keeping it until it is deleted", name, file)
+	}
+	if changed {
+		w.Events.Publish(events.Event{
+			Type: events.PipelineChanged,
+			Data: file,
+		})
+	}
+	return nil
+}
+
+// syncImports watches the imports of the pipeline file when they are not the ones watched
+func (w *Watcher) syncImports(file string, imports []string) error {
+	w.mu.Lock()
+	have, ok := w.imports[file]
+	if ok && reflect.DeepEqual(have, imports) {
+		w.mu.Unlock()
+		return nil
+	}
+	w.imports[file] = imports
+	w.mu.Unlock()
+	// the imports of a file not yet saved are watched once its stages are saved
+	if !ok {
+		return nil
+	}
+	return w.Sync()
+}
+
+// updateSteps saves the steps of the stage, the steps of the stored stage that are not in the
+// steps are deleted. It reports whether any of the steps changed.
+func updateSteps(ctx context.Context, tx bun.Tx, stored *db.Stage, steps db.Steps) (bool, error) {
+	storedByName := make(map[string]*db.StageStep, len(stored.Steps))
+	for _, s := range stored.Steps {
+		storedByName[s.Name] = s
+	}
+
+	var added db.Steps
+	var changed bool
+	for _, s := range steps {
+		s.StageID = stored.ID
+		have, ok := storedByName[s.Name]
+		if !ok {
+			added = append(added, s)
+			continue
+		}
+		delete(storedByName, s.Name)
+		if sameStep(have, s) {
+			continue
+		}
+		changed = true
+		s.ID = have.ID
+		_, err := tx.NewUpdate().
+			Model(s).
+			Column("image", "service", "depends_on", "when").
+			// the zero service is the default of the column rather than null
+			Value("service", "?", s.Service).
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(added) > 0 {
+		changed = true
+		if _, err := tx.NewInsert().Model(&added).Exec(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	if len(storedByName) > 0 {
+		changed = true
+		var removed []int
+		for _, s := range storedByName {
+			removed = append(removed, s.ID)
+		}
+		_, err := tx.NewDelete().
+			Model((*db.StageStep)(nil)).
+			Where("id IN (?)", bun.In(removed)).
+			Exec(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return changed, nil
+}
+
+func sameStage(a, b *db.Stage) bool {
+	return a.Kind == b.Kind &&
+		a.Type == b.Type &&
+		reflect.DeepEqual(a.DependsOn, b.DependsOn) &&
+		reflect.DeepEqual(a.Trigger, b.Trigger) &&
+		reflect.DeepEqual(a.Platform, b.Platform)
+}
+
+func sameStep(a, b *db.StageStep) bool {
+	return a.Image == b.Image &&
+		a.Service == b.Service &&
+		reflect.DeepEqual(a.DependsOn, b.DependsOn) &&
+		reflect.DeepEqual(a.When, b.When)
+}
+
+// toStage is the database stage of the parsed stage
+func toStage(p *finder.Stage) *db.Stage {
+	stage := &db.Stage{
+		PipelineFile: p.PipelineFile,
+		PipelinePath: p.PipelinePath,
+		Name:         p.Name,
+		Kind:         p.Kind,
+		Type:         p.Type,
+		DependsOn:    p.DependsOn,
+		Trigger:      toConditions(p.Trigger),
+	}
+	if p.Platform != nil {
+		stage.Platform = &db.Platform{
+			OS:      p.Platform.OS,
+			Arch:    p.Platform.Arch,
+			Variant: p.Platform.Variant,
+			Version: p.Platform.Version,
+		}
+	}
+	for _, s := range p.Steps {
+		stage.Steps = append(stage.Steps, &db.StageStep{
+			Name:      s.Name,
+			Image:     s.Image,
+			Service:   s.Service,
+			DependsOn: s.DependsOn,
+			When:      toConditions(s.When),
+		})
+	}
+	return stage
+}
+
+func toConditions(conditions finder.Conditions) db.Conditions {
+	if conditions == nil {
+		return nil
+	}
+	c := make(db.Conditions, len(conditions))
+	for k, v := range conditions {
+		c[k] = db.Condition{
+			Include: v.Include,
+			Exclude: v.Exclude,
+		}
+	}
+	return c
+}
@@ -0,0 +1,223 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/harness/drone-ci-docker-extension/pkg/db"
+	"github.com/harness/drone-ci-docker-extension/pkg/events"
+	"github.com/harness/drone-ci-docker-extension/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const pipeline = `kind: pipeline
+type: docker
+name: default
+steps:
+- name: build
+  image: golang
+- name: test
+  image: golang
+`
+
+const editedPipeline = `kind: pipeline
+type: docker
+name: default
+steps:
+- name: build
+  image: golang:1.19
+- name: unit test
+  image: golang
+  depends_on: [build]
+---
+kind: pipeline
+type: docker
+name: deploy
+depends_on: [default]
+steps:
+- name: push
+  image: plugins/docker
+`
+
+func newWatcher(t *testing.T, ctx context.Context) (*Watcher, *events.Broker) {
+	log := utils.LogSetup(os.Stdout, "debug")
+	dbc := db.New(
+		db.WithContext(ctx),
+		db.WithDBFile(path.Join(t.TempDir(), "watch.db")),
+		db.WithLogger(log))
+	dbc.Init()
+	t.Cleanup(func() {
+		dbc.DB.Close()
+	})
+
+	broker := events.NewBroker(ctx, log)
+	w, err := New(ctx, dbc.DB, log, WithEvents(broker), WithDebounce(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, broker
+}
+
+// stepNames are the names of the steps of the stages of the file keyed by the stage name
+func stepNames(t *testing.T, w *Watcher, file string) map[string][]string {
+	var stages db.Stages
+	err := w.DB.NewSelect().
+		Model(&stages).
+		Relation("Steps").
+		Where("pipeline_file = ?", file).
+		Scan(w.Ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string][]string)
+	for _, stage := range stages {
+		names[stage.Name] = []string{}
+		for _, s := range stage.Steps {
+			names[stage.Name] = append(names[stage.Name], s.Name)
+		}
+		sort.Strings(names[stage.Name])
+	}
+	return names
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.TODO()
+	w, broker := newWatcher(t, ctx)
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	file := filepath.Join(t.TempDir(), ".drone.yml")
+	if err := os.WriteFile(file, []byte(pipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if assert.NoError(t, w.Update(file)) {
+		assert.Equal(t, map[string][]string{"default": {"build", "test"}}, stepNames(t, w, file))
+		assert.Equal(t, events.Event{Type: events.PipelineChanged, Data: file}, withoutTimestamp(<-ch))
+	}
+
+	if err := os.WriteFile(file, []byte(editedPipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if assert.NoError(t, w.Update(file)) {
+		assert.Equal(t, map[string][]string{
+			"default": {"build", "unit test"},
+			"deploy":  {"push"},
+		}, stepNames(t, w, file))
+		assert.Equal(t, events.PipelineChanged, (<-ch).Type)
+
+		var step db.StageStep
+		err := w.DB.NewSelect().
+			Model(&step).
+			Where("name = ?", "unit test").
+			Scan(ctx)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []string{"build"}, step.DependsOn)
+		}
+	}
+
+	// unchanged stages are not published
+	assert.NoError(t, w.Update(file))
+	// a broken file leaves the stages as is
+	if err := os.WriteFile(file, []byte("kind: pipeline\nsteps: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, w.Update(file))
+	assert.Len(t, stepNames(t, w, file), 2)
+	select {
+	case e := <-ch:
+		t.Errorf("Expecting no event but got %s", e.Type)
+	default:
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, broker := newWatcher(t, ctx)
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	file := filepath.Join(t.TempDir(), ".drone.yml")
+	if err := os.WriteFile(file, []byte(pipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Update(file); err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	go w.Watch()
+
+	// saved the way the editors replace the file
+	tmp := file + ".swp"
+	if err := os.WriteFile(tmp, []byte(editedPipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-ch:
+		assert.Equal(t, events.PipelineChanged, e.Type)
+		assert.Equal(t, file, e.Data)
+		assert.Contains(t, stepNames(t, w, file), "deploy")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expecting the stages to be updated on change of the pipeline file")
+	}
+}
+
+func TestWatchImports(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w, broker := newWatcher(t, ctx)
+	ch, unsubscribe := broker.Subscribe()
+	defer unsubscribe()
+
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib", "steps.libsonnet")
+	if err := os.Mkdir(filepath.Dir(lib), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lib, []byte("[{ name: 'build', image: 'golang' }]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, ".drone.jsonnet")
+	jsonnetPipeline := `{ kind: 'pipeline', type: 'docker', name: 'default', steps: import 'lib/steps.libsonnet' }`
+	if err := os.WriteFile(file, []byte(jsonnetPipeline), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Update(file); err != nil {
+		t.Fatal(err)
+	}
+	<-ch
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	go w.Watch()
+
+	if err := os.WriteFile(lib, []byte("[{ name: 'build', image: 'golang' }, { name: 'test', image: 'golang' }]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case e := <-ch:
+		assert.Equal(t, events.PipelineChanged, e.Type)
+		assert.Equal(t, file, e.Data)
+		assert.Equal(t, map[string][]string{"default": {"build", "test"}}, stepNames(t, w, file))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expecting the stages to be updated on change of the imported file")
+	}
+}
+
+func withoutTimestamp(e events.Event) events.Event {
+	e.Timestamp = time.Time{}
+	return e
+}
@@ -2,16 +2,16 @@ import React, { useEffect, useState } from 'react';
 import { useSelector } from 'react-redux';
 import { Button, Grid, Stack, Typography } from '@mui/material';
 import ImportOrLoadStages from './components/dialogs/ImportOrLoadStages';
-import { getDockerDesktopClient } from './utils';
+import { subscribeEvents } from './utils';
 import { dataLoadStatus, importPipelines, refreshPipelines } from './features/pipelinesSlice';
 import { useAppDispatch } from './app/hooks';
+import { PipelineEventType } from './features/types';
 import { Pipelines } from './components/Pipelines';
 
 export function App() {
   const [openImportDialog, setOpenImportDialog] = useState<boolean>(false);
   const pipelinesStatus = useSelector(dataLoadStatus);
   const dispatch = useAppDispatch();
-  const ddClient = getDockerDesktopClient();
 
   /* Handlers */
   const handleImportPipeline = () => {
@@ -28,47 +28,14 @@ export function App() {
     if (pipelinesStatus === 'idle') {
       dispatch(importPipelines());
     }
-    let process;
-    const extensionContainersEvents = async () => {
-      console.debug("listening to extension's container events...");
-      process = await ddClient.docker.cli.exec(
-        'events',
-        [
-          '--format',
-          `"{{ json . }}"`,
-          '--filter',
-          'type=container',
-          '--filter',
-          'event=create',
-          '--filter',
-          'event=destroy',
-          '--filter',
-          'label=com.docker.compose.project=drone_drone-ci-docker-extension-desktop-extension',
-          '--filter',
-          'label=io.drone.desktop.ui.refresh=true'
-        ],
-        {
-          stream: {
-            onOutput() {
-              dispatch(refreshPipelines());
-            },
-            onError(error) {
-              console.error(error);
-            },
-            onClose(exitCode) {
-              console.debug('onClose with exit code ' + exitCode);
-            },
-            splitOutputLines: true
-          }
-        }
-      );
-    };
-
-    extensionContainersEvents();
-    return () => {
-      if (process) {
-        process.close();
+    //the pipelines are refreshed on the status events, the log events are followed by the log viewer
+    const process = subscribeEvents((event) => {
+      if (event.type !== PipelineEventType.LOG_APPENDED) {
+        dispatch(refreshPipelines());
       }
+    });
+    return () => {
+      process.close();
     };
   }, []);
 
@@ -30,6 +30,10 @@ export const PipelineStatus = (props) => {
         setStatusColor('error');
         setStatusText('stopped');
         break;
+      case 5:
+        setStatusColor('gray');
+        setStatusText('skipped');
+        break;
       default:
         setStatusColor('primary');
         setStatusText('none');
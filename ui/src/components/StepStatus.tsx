@@ -4,6 +4,7 @@ import { Status } from '../features/types';
 import RunCircleIcon from '@mui/icons-material/RunCircle';
 import CheckCircleIcon from '@mui/icons-material/CheckCircle';
 import ErrorIcon from '@mui/icons-material/Error';
+import SkipNextIcon from '@mui/icons-material/SkipNext';
 export const StepStatus = (props: { status: Status }) => {
   const { status } = props;
 
@@ -14,6 +15,8 @@ export const StepStatus = (props: { status: Status }) => {
       return <ErrorIcon color="error" />;
     case Status.SUCCESS:
       return <CheckCircleIcon color="success" />;
+    case Status.SKIPPED:
+      return <SkipNextIcon color="disabled" />;
     default:
       return <PendingIcon color="action">None</PendingIcon>;
   }
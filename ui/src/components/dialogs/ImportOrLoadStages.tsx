@@ -8,7 +8,7 @@ import DialogTitle from '@mui/material/DialogTitle';
 import { getDockerDesktopClient } from '../../utils';
 import { useAppDispatch } from '../../app/hooks';
 import { loadStages } from '../../features/pipelinesSlice';
-import { Pipeline, Stage } from '../../features/types';
+import { DiscoveryError, DiscoveryResult, Pipeline, Stage } from '../../features/types';
 import * as _ from 'lodash';
 
 export default function ImportOrLoadStages({ ...props }) {
@@ -49,6 +49,15 @@ export default function ImportOrLoadStages({ ...props }) {
       props.onClose();
     }
   };
+  const reportDiscoveryErrors = (errors: DiscoveryError[]) => {
+    if (!errors || errors.length === 0) {
+      return;
+    }
+    errors.forEach((e) => console.debug('Unable to read %s: %s', e.file, e.message));
+    const files = errors.map((e) => (e.line ? `${e.file}:${e.line} ${e.message}` : `${e.file} ${e.message}`));
+    ddClient.desktopUI.toast.warning(`Skipped ${errors.length} broken pipeline(s): ${files.join(', ')}`);
+  };
+
   const selectStageFromDir = async () => {
     const result = await ddClient.desktopUI.dialog.showOpenDialog({
       properties: ['openDirectory'],
@@ -63,9 +72,14 @@ export default function ImportOrLoadStages({ ...props }) {
       const cmd = await ddClient.extension.host.cli.exec('pipelines-finder', ['-path', result.filePaths[0]]);
       console.debug(' Pipeline find %s', JSON.stringify(cmd.stdout));
       if (cmd.stdout) {
-        const droneFiles = JSON.parse(cmd.stdout);
-        console.debug('Drone files %s', droneFiles.length);
-        savePipelines(droneFiles);
+        const discovered = JSON.parse(cmd.stdout) as DiscoveryResult;
+        console.debug('Drone files %s', discovered.stages.length);
+        reportDiscoveryErrors(discovered.errors);
+        if (discovered.stages.length > 0) {
+          savePipelines(discovered.stages);
+        } else {
+          props.onClose();
+        }
       } else if (cmd.stderr) {
         ddClient.desktopUI.toast.error(`Error importing pipelines : ${JSON.stringify(cmd.stderr)}`);
       }
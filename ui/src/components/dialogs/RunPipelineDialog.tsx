@@ -26,7 +26,7 @@ import BackspaceIcon from '@mui/icons-material/Backspace';
 import { getDockerDesktopClient, md5 } from '../../utils';
 import { refreshPipelines, selectPipelines, selectStagesByPipeline } from '../../features/pipelinesSlice';
 import * as _ from 'lodash';
-import { Pipeline, Status } from '../../features/types';
+import { Pipeline, RunOptions, Status } from '../../features/types';
 import { RootState } from '../../app/store';
 
 export default function RunPipelineDialog({ ...props }) {
@@ -156,114 +156,95 @@ export default function RunPipelineDialog({ ...props }) {
     ddClient.desktopUI.toast.error(`Error running pipeline :${JSON.stringify(err)}`);
   };
 
-  const runPipeline = async () => {
-    console.debug('Running pipeline ', pipelineFile);
-    logHandler(undefined, true);
-
-    const pipelineExecArgs = new Array<string>();
-
-    //Add --pipeline flag
-    if (includeStages && includeStages.length > 0) {
-      console.debug('Adding Stage to run %s', includeStages[0]);
-      pipelineExecArgs.push(`--pipeline=${includeStages[0]}`);
-    }
-
-    // Add --trusted arg
-    if (trusted) {
-      console.debug('Adding trusted');
+  //runOnHost runs the stage with drone exec on the host, for the files the backend could not read
+  //e.g. the ones of windows hosts, the progress is tracked by the backend from the step containers
+  const runOnHost = (stageName: string, runOptions: RunOptions) => {
+    const pipelineExecArgs = [`--pipeline=${stageName}`];
+    if (runOptions.trusted) {
       pipelineExecArgs.push(`--trusted`);
     }
-
-    //Add --env-file arg
-    if (envFile) {
-      console.debug('Adding envfile');
-      pipelineExecArgs.push(`--env-file=${envFile}`);
+    if (runOptions.envFile) {
+      pipelineExecArgs.push(`--env-file=${runOptions.envFile}`);
     }
-
-    //Add --secret-file arg
-    if (secretFile) {
-      console.debug('Adding secretFile');
-      pipelineExecArgs.push(`--secret-file=${secretFile}`);
+    if (runOptions.secretFile) {
+      pipelineExecArgs.push(`--secret-file=${runOptions.secretFile}`);
     }
-
-    //Add steps to include
-    if (includeSteps && includeSteps?.length > 0) {
-      console.debug('Adding includeSteps');
-      const incSteps = includeSteps.map((s) => `--include="${s}"`);
-      console.debug('Included Steps ', JSON.stringify(incSteps));
-      pipelineExecArgs.push(...incSteps);
+    if (runOptions.include && runOptions.include.length > 0) {
+      pipelineExecArgs.push(...runOptions.include.map((s) => `--include="${s}"`));
     }
-
-    //Configure network
-    if (dockerNetwork && dockerNetwork !== 'none') {
-      console.debug('Adding Docker Network');
-      pipelineExecArgs.push(`--network=${dockerNetwork}`);
+    if (runOptions.network) {
+      pipelineExecArgs.push(`--network=${runOptions.network}`);
     }
-
     //The pipeline pid file id
     pipelineExecArgs.push(md5(pipelineFile));
     //The pipeline file to use
     pipelineExecArgs.push(pipelineFile);
-
     console.debug('Pipeline Exec Args %s', JSON.stringify(pipelineExecArgs));
 
-    // ddClient.extension.vm.cli.exec('ls', ['-ltr', '/data'], {
-    //   stream: {
-    //     onOutput(data) {
-    //       console.log('ls %s', JSON.stringify(data));
-    //     },
-    //     onError(data) {
-    //       console.log('ls error %s', JSON.stringify(data));
-    //     }
-    //   }
-    // });
+    ddClient.extension.host.cli.exec('run-drone', pipelineExecArgs, {
+      stream: {
+        onOutput(data) {
+          // any signals to kill the drone process will be treated
+          // graciously - as it will denote docker signal 137 which
+          // wil be shown as "Stopped"
+          if (data.stderr && data.stderr !== 'received signal, terminating process') {
+            showError(data.stderr);
+          }
+        },
+        splitOutputLines: true
+      }
+    });
+  };
+
+  const runPipeline = async () => {
+    console.debug('Running pipeline ', pipelineFile);
+    logHandler(undefined, true);
 
     const stageName = includeStages && includeStages.length > 0 ? includeStages[0] : undefined;
+    const stage = stages.find((s) => s.name === stageName);
+    if (!stage) {
+      showError(`No stage ${stageName} to run in pipeline ${pipelineFile}`);
+      props.onClose();
+      return;
+    }
+
+    //the stage is run by the backend, the progress is tracked from its events
+    const runOptions: RunOptions = {
+      include: includeSteps,
+      trusted: trusted
+    };
+    if (envFile) {
+      runOptions.envFile = envFile;
+    }
+    if (secretFile) {
+      runOptions.secretFile = secretFile;
+    }
+    if (dockerNetwork && dockerNetwork !== 'none') {
+      runOptions.network = dockerNetwork;
+    }
+    console.debug('Run Options %s', JSON.stringify(runOptions));
 
     try {
-      let once = 0;
-      ddClient.extension.host.cli.exec('run-drone', pipelineExecArgs, {
-        stream: {
-          onOutput(data) {
-            console.debug('onOutput:%s', JSON.stringify(data));
-            if (data.stderr) {
-              // any signals to kill the drone process will be treated 
-              // graciously - as it will denote docker signal 137 which
-              // wil be shown as "Stopped"
-              if (data.stderr === 'received signal, terminating process'){
-                return;
-              }
-              showError(data.stderr);
-              return;
-            }
-            if (once === 1) {
-              const stage = stages.find((s) => s.name === stageName);
-              //make it writable and set the status
-              const runningStage = Object.assign({}, stage);
-              //Reset step status
-              let steps = runningStage.steps;
-              for (let i = 0; i < steps.length; i++) {
-                //make it writable and set the status
-                const oldStep = steps[i];
-                const toBeRunStep = Object.assign({}, oldStep);
-                toBeRunStep.status = Status.NONE;
-                console.debug('toBeRunStep %s', JSON.stringify(toBeRunStep));
-                steps = [...steps.slice(0, i), toBeRunStep, ...steps.slice(i + 1)];
-              }
-              runningStage.steps = steps;
-              console.debug('runningStage %s', JSON.stringify(runningStage));
-              logHandler(
-                {
-                  stage: runningStage
-                },
-                true
-              );
-            }
-            once++;
-          },
-          splitOutputLines: true
+      try {
+        await ddClient.extension.vm.service.post(`/stage/${stage.id}/run`, runOptions);
+      } catch (err) {
+        //the files are not shared with the backend
+        if (err?.statusCode !== 422) {
+          throw err;
         }
-      });
+        console.debug('Running pipeline %s on the host, %s', pipelineFile, err?.message);
+        runOnHost(stage.name, runOptions);
+      }
+      //make it writable and reset the step statuses
+      const runningStage = Object.assign({}, stage);
+      runningStage.steps = stage.steps.map((step) => Object.assign({}, step, { status: Status.NONE }));
+      console.debug('runningStage %s', JSON.stringify(runningStage));
+      logHandler(
+        {
+          stage: runningStage
+        },
+        true
+      );
     } catch (err) {
       showError(err);
     } finally {
@@ -10,24 +10,39 @@ import {
   Grid,
   Typography
 } from '@mui/material';
+import { useSelector } from 'react-redux';
 import { getDockerDesktopClient, md5 } from '../../utils';
+import { selectStagesByPipeline } from '../../features/pipelinesSlice';
+import { RootState } from '../../app/store';
+import { Status } from '../../features/types';
 
 export default function StopPipelineDialog({ ...props }) {
   const [actionInProgress, setActionInProgress] = React.useState<boolean>(false);
 
   const ddClient = getDockerDesktopClient();
   const { pipelineFile } = props;
+  const stages = useSelector((state: RootState) => selectStagesByPipeline(state, pipelineFile));
 
   const handleStopPipeline = async () => {
     setActionInProgress(true);
     try {
       console.log("Stop Pipeline with id %s ", pipelineFile)
-      const pipelineId = md5(pipelineFile);
-      await ddClient.extension.host.cli.exec("kill-drone", [pipelineId]);
+      //the backend cancels the runs of the stage, or kills the step containers of the runs it did not start
+      const running = stages.filter((s) => s.status === Status.RUNNING);
+      //the pipelines run on the host, as the backend could not read their files, have their drone exec
+      //killed first for it not to start the next steps
+      try {
+        await ddClient.extension.host.cli.exec('kill-drone', [md5(pipelineFile)]);
+      } catch (err) {
+        console.debug('No drone exec of %s on the host, %s', pipelineFile, JSON.stringify(err));
+      }
+      for (const stage of running) {
+        await ddClient.extension.vm.service.post(`/stage/${stage.id}/cancel`, {});
+      }
       ddClient.desktopUI.toast.success(`Pipeline ${pipelineFile} successfully stopped`);
     } catch (err) {
       console.log("ERR %s",JSON.stringify(err))
-      ddClient.desktopUI.toast.error(`Error stopping pipeline, ${err?.stderr}`);
+      ddClient.desktopUI.toast.error(`Error stopping pipeline, ${err?.message}`);
     } finally {
       props.onClose();
     }
@@ -1,6 +1,6 @@
 import { useState, useMemo, useEffect } from 'react';
 import { useLocation, useNavigate } from 'react-router-dom';
-import { getDockerDesktopClient, md5, pipelineDisplayName, pipelinePath, vscodeURI } from '../../utils';
+import { getDockerDesktopClient, md5, pipelineDisplayName, pipelinePath, subscribeEvents, vscodeURI } from '../../utils';
 import ArrowBackIosIcon from '@mui/icons-material/ArrowBackIos';
 import RemovePipelineDialog from '../dialogs/RemovePipelineDialog';
 import PlayCircleOutlineOutlinedIcon from '@mui/icons-material/PlayCircleOutlineOutlined';
@@ -26,7 +26,7 @@ import { StepStatus } from '../StepStatus';
 import { LazyLog, ScrollFollow } from 'react-lazylog';
 import { RootState } from '../../app/store';
 import React from 'react';
-import { Stage, Status, Step } from '../../features/types';
+import { PipelineEventType, Stage, Status, Step } from '../../features/types';
 import { ExecProcess } from '@docker/extension-api-client-types/dist/v1';
 import { useAppDispatch } from '../../app/hooks';
 
@@ -179,47 +179,14 @@ export const StageRunnerView = (props) => {
   }, [pipelineFile]);
 
   useEffect(() => {
-    let process;
-    const extensionContainersEvents = async () => {
-      console.debug("listening to extension's container events...");
-      process = await ddClient.docker.cli.exec(
-        'events',
-        [
-          '--format',
-          `"{{ json . }}"`,
-          '--filter',
-          'type=container',
-          '--filter',
-          'event=create',
-          '--filter',
-          'event=destroy',
-          '--filter',
-          'label=com.docker.compose.project=drone_drone-ci-docker-extension-desktop-extension',
-          '--filter',
-          'label=io.drone.desktop.ui.refresh=true'
-        ],
-        {
-          stream: {
-            onOutput() {
-              dispatch(refreshPipelines());
-            },
-            onError(error) {
-              console.error(error);
-            },
-            onClose(exitCode) {
-              console.debug('onClose with exit code ' + exitCode);
-            },
-            splitOutputLines: true
-          }
-        }
-      );
-    };
-
-    extensionContainersEvents();
-    return () => {
-      if (process) {
-        process.close();
+    //the pipelines are refreshed on the status events, the log events are followed by the log viewer
+    const process = subscribeEvents((event) => {
+      if (event.type !== PipelineEventType.LOG_APPENDED) {
+        dispatch(refreshPipelines());
       }
+    });
+    return () => {
+      process.close();
     };
   }, []);
 
@@ -12,7 +12,8 @@ export const enum Status {
   SUCCESS,
   RUNNING,
   ERROR,
-  STOP
+  STOP,
+  SKIPPED
 }
 
 export interface Event {
@@ -26,6 +27,26 @@ export interface Event {
   };
 }
 
+//PipelineEventType is the type of the events streamed by the backend endpoint GET /events
+export enum PipelineEventType {
+  STAGE_STATUS_CHANGED = 'stage-status-changed',
+  STEP_STARTED = 'step-started',
+  STEP_FINISHED = 'step-finished',
+  LOG_APPENDED = 'log-appended',
+  PIPELINE_CHANGED = 'pipeline-changed'
+}
+
+//PipelineEvent is the stage or step event streamed by the backend
+export interface PipelineEvent {
+  type: PipelineEventType;
+  stageId: number;
+  stepId?: number;
+  stepName?: string;
+  status: Status;
+  data?: string;
+  timestamp: string;
+}
+
 //Step defines the single Pipeline step row that is displayed
 //in the UI
 export interface Step {
@@ -35,6 +56,27 @@ export interface Step {
   image: string;
   status: Status;
   isService?: boolean;
+  dependsOn?: string[];
+  when?: Conditions;
+}
+
+//Condition is the values that a trigger or when
+//condition includes and excludes
+export interface Condition {
+  include?: string[];
+  exclude?: string[];
+}
+
+//Conditions are keyed by the condition name e.g. branch
+export interface Conditions {
+  [key: string]: Condition;
+}
+
+export interface Platform {
+  os?: string;
+  arch?: string;
+  variant?: string;
+  version?: string;
 }
 
 //Pipeline defines the single Pipeline row that is displayed
@@ -53,6 +95,38 @@ export interface Stage {
   pipelineFile: string;
   status: Status;
   steps: Step[];
+  kind?: string;
+  type?: string;
+  dependsOn?: string[];
+  trigger?: Conditions;
+  platform?: Platform;
+}
+
+//RunOptions are the options of the stage run by the backend
+//endpoint POST /stage/:id/run
+export interface RunOptions {
+  include?: string[];
+  exclude?: string[];
+  secretFile?: string;
+  envFile?: string;
+  trusted?: boolean;
+  resumeAt?: string;
+  network?: string;
+}
+
+//DiscoveryError is a pipeline file or a document of it
+//that pipelines-finder could not read
+export interface DiscoveryError {
+  file: string;
+  document?: number;
+  line?: number;
+  message: string;
+}
+
+//DiscoveryResult is the output of pipelines-finder
+export interface DiscoveryResult {
+  stages: Stage[];
+  errors: DiscoveryError[];
 }
 
 export interface StepPayload {
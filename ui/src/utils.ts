@@ -1,7 +1,7 @@
 import { createDockerDesktopClient } from '@docker/extension-api-client';
 import { DockerDesktopClient } from '@docker/extension-api-client-types/dist/v1';
 import { Md5 } from 'ts-md5/dist/md5';
-import { Status, Step } from './features/types';
+import { PipelineEvent, Status, Step } from './features/types';
 
 let client: DockerDesktopClient;
 
@@ -12,6 +12,38 @@ export function getDockerDesktopClient() {
   return client;
 }
 
+//backendSocket is the socket the backend listens on inside the extension container
+const backendSocket = '/run/guest-services/extension-drone-ci.sock';
+
+//subscribeEvents streams the stage and step events of the backend endpoint GET /events, the
+//service client of the extension can't stream the responses hence curl is run in the backend container.
+//The returned process has to be closed to unsubscribe.
+export function subscribeEvents(onEvent: (event: PipelineEvent) => void, stageId?: number) {
+  const url = stageId ? `http://localhost/events?stage=${stageId}` : 'http://localhost/events';
+  return getDockerDesktopClient().extension.vm.cli.exec('curl', ['-sN', '--unix-socket', backendSocket, url], {
+    stream: {
+      onOutput(data) {
+        //the events are sent as "event: <type>" and "data: <json>" lines
+        if (!data.stdout || !data.stdout.startsWith('data:')) {
+          return;
+        }
+        try {
+          onEvent(JSON.parse(data.stdout.substring('data:'.length)) as PipelineEvent);
+        } catch (err) {
+          console.error('Error parsing event %s, %s', data.stdout, err);
+        }
+      },
+      onError(error) {
+        console.error(error);
+      },
+      onClose(exitCode) {
+        console.debug('Events stream closed with exit code ' + exitCode);
+      },
+      splitOutputLines: true
+    }
+  });
+}
+
 export function pipelineFQN(pipelinePath: string, stageName: string): string {
   if (stageName.indexOf('/') != -1) {
     stageName = stageName.split('/')[1];